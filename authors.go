@@ -0,0 +1,117 @@
+// authors.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Author pages
+//
+// The category lists only show the top 500 or so, and only in our
+// categories, so we're missing plenty of books by authors we like. The
+// authors list source reads the author page of everyone whose books are good
+// enough, and their titles go through the normal list filters:
+//
+// 	laud crawl --source authors
+//
+// An author is good enough with a book rated at least AUTHOR_MIN_RATING
+// (default 4.5), or popularity adding up to AUTHOR_MIN_POPULARITY (default
+// 500) across their books. Set either to 0 to ignore it.
+
+const audibleUrl = "https://www.audible.co.uk"
+
+// pages of titles to read on an author page, we stop early when they run out
+const authorPagesToFetch = 10
+
+type authorSource struct {
+	author string
+	link   string
+}
+
+// the author's id is on the end of their link, /author/Brandon-Sanderson/B001IGFHW6
+func (a authorSource) Name() string {
+	return "author/" + path.Base(a.link)
+}
+
+func (a authorSource) Friendly() string {
+	return "books by " + a.author
+}
+
+func (a authorSource) Category() Category  { return "" }
+func (a authorSource) Sort() Sort          { return sortFeatured }
+func (a authorSource) Pages() int          { return authorPagesToFetch }
+func (a authorSource) URL(page int) string { return pageURL(a.link, page) }
+
+// the link as stored is relative, with tracking on the end
+func absoluteLink(link string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" || u.Path == "/" {
+		return "", fmt.Errorf("no path in '%s'", link)
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	if u.Host == "" {
+		return audibleUrl + u.Path, nil
+	}
+	return u.String(), nil
+}
+
+type authorStats struct {
+	author     string
+	link       string
+	bestRating float64
+	popularity float64
+}
+
+func (bc *BookCollector) authorSources() []ListSource {
+	minRating := envFloat("AUTHOR_MIN_RATING", 4.5)
+	minPopularity := envFloat("AUTHOR_MIN_POPULARITY", 500)
+
+	byLink := map[string]*authorStats{}
+	err := forEachRow(bc.db, "books", "asin,author,authorlink,rating,popularity,hidden", func(b Book) {
+		if b.Hidden || b.AuthorLink == "" {
+			return
+		}
+		link, err := absoluteLink(b.AuthorLink)
+		if err != nil {
+			return
+		}
+		stats, ok := byLink[link]
+		if !ok {
+			stats = &authorStats{author: b.Author, link: link}
+			byLink[link] = stats
+		}
+		if b.Rating > stats.bestRating {
+			stats.bestRating = b.Rating
+		}
+		stats.popularity += b.PopularityScore
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+
+	authors := []*authorStats{}
+	for _, stats := range byLink {
+		if (minRating > 0 && stats.bestRating >= minRating) || (minPopularity > 0 && stats.popularity >= minPopularity) {
+			authors = append(authors, stats)
+		}
+	}
+	// the most popular first, in case we don't get to the end
+	sort.Slice(authors, func(i, j int) bool { return authors[i].popularity > authors[j].popularity })
+	log.Printf("INFO: %d of %d authors rated %g or with popularity %g\n", len(authors), len(byLink), minRating, minPopularity)
+
+	sources := []ListSource{}
+	for _, a := range authors {
+		sources = append(sources, authorSource{author: a.author, link: a.link})
+	}
+	return sources
+}
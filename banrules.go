@@ -0,0 +1,202 @@
+// banrules.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Ban rules
+//
+// The original banned_words were a case-sensitive strings.Contains over the
+// whole list item, and banned_tags an exact lookup, which caught too much
+// ('Orc' in 'Orchestra') and missed too much ('litrpg' vs 'LitRPG').
+//
+// A rule now says how to match (substring, whole word, regex or exact), which
+// field to look in, whether case matters, and a list of exceptions: if an
+// exception is found in the same field the rule doesn't fire, so 'Warhammer'
+// can be banned without losing 'The Warhammer of Thor'. Author, series and asin
+// bans are just rules on those fields.
+//
+// Rules live in the ban_rules table with a reason and an enabled flag. The old
+// banned_words and banned_tags tables are still loaded, as rules that behave
+// as they always did: banned words only look in the list field, the text a
+// list item shows, so not the summary.
+
+type BanKind string
+
+const (
+	banSubstring BanKind = "substring"
+	banWord      BanKind = "word"
+	banRegex     BanKind = "regex"
+	banExact     BanKind = "exact"
+)
+
+type BanField string
+
+const (
+	fieldTitle   BanField = "title"
+	fieldAuthor  BanField = "author"
+	fieldSeries  BanField = "series"
+	fieldTags    BanField = "tags"
+	fieldSummary BanField = "summary"
+	fieldText    BanField = "text" // the whole list item, or everything we know on a product page
+	fieldList    BanField = "list" // the whole list item, or just what it would show on a product page
+	fieldAsin    BanField = "asin"
+)
+
+type BanRule struct {
+	Id            int      `json:"id"`
+	Kind          BanKind  `json:"kind"`
+	Field         BanField `json:"field"`
+	Pattern       string   `json:"pattern"`
+	CaseSensitive bool     `json:"case_sensitive"`
+	Exceptions    []string `json:"exceptions"`
+	Reason        string   `json:"reason"`
+	Enabled       bool     `json:"enabled"`
+
+	skip       SkipReason
+	rx         *regexp.Regexp
+	exceptions []*regexp.Regexp
+}
+
+// the values a rule can look at, a field can have more than one (tags)
+type banFields map[BanField][]string
+
+func (r *BanRule) compile() error {
+	flags := ""
+	if !r.CaseSensitive {
+		flags = "(?i)"
+	}
+	expr := ""
+	switch r.Kind {
+	case banSubstring, "":
+		expr = regexp.QuoteMeta(r.Pattern)
+	case banWord:
+		expr = `\b` + regexp.QuoteMeta(r.Pattern) + `\b`
+	case banRegex:
+		expr = r.Pattern
+	case banExact:
+		expr = `^` + regexp.QuoteMeta(r.Pattern) + `$`
+	default:
+		return fmt.Errorf("ban rule %d: unknown kind '%s'", r.Id, r.Kind)
+	}
+	rx, err := regexp.Compile(flags + expr)
+	if err != nil {
+		return fmt.Errorf("ban rule %d: %w", r.Id, err)
+	}
+	r.rx = rx
+	// exceptions are always plain substrings
+	for _, exception := range r.Exceptions {
+		r.exceptions = append(r.exceptions, regexp.MustCompile(flags+regexp.QuoteMeta(exception)))
+	}
+	if r.Field == "" {
+		r.Field = fieldText
+	}
+	if r.skip == "" {
+		r.skip = skipBanRule
+	}
+	return nil
+}
+
+// returns the text that matched, which can be "", and whether the rule applies
+func (r *BanRule) match(fields banFields) (string, bool) {
+	for _, value := range fields[r.Field] {
+		loc := r.rx.FindStringIndex(value)
+		if loc == nil {
+			continue
+		}
+		excepted := false
+		for _, exception := range r.exceptions {
+			if exception.MatchString(value) {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			return value[loc[0]:loc[1]], true
+		}
+	}
+	return "", false
+}
+
+// what to write in skipped_books.match
+func (r *BanRule) describe(matched string) string {
+	if r.skip != skipBanRule {
+		return matched
+	}
+	return fmt.Sprintf("%s (rule %d: %s)", matched, r.Id, r.Reason)
+}
+
+type banRules []*BanRule
+
+// returns the first rule that fires, and the text it matched
+func (rules banRules) match(fields banFields) (*BanRule, string) {
+	for _, r := range rules {
+		if m, ok := r.match(fields); ok {
+			return r, m
+		}
+	}
+	return nil, ""
+}
+
+func bookBanFields(b *Book) banFields {
+	return banFields{
+		fieldTitle:   {b.Title, b.SubTitle},
+		fieldAuthor:  {b.Author},
+		fieldSeries:  {b.Series},
+		fieldTags:    b.Tags,
+		fieldSummary: {b.Summary},
+		fieldText:    {strings.Join([]string{b.Title, b.SubTitle, b.Author, b.Series, b.Summary}, "\n")},
+		fieldList:    {strings.Join([]string{b.Title, b.SubTitle, b.Author, b.Series}, "\n")},
+		fieldAsin:    {b.Id},
+	}
+}
+
+func loadBanRules(db *supabase.Client) banRules {
+	rules := banRules{}
+
+	// load the ban rules
+	dbRules := []*BanRule{}
+	count, err := db.From("ban_rules").Select("*", "exact", false).Eq("enabled", "true").ExecuteTo(&dbRules)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d ban rules in database\n", count)
+	rules = append(rules, dbRules...)
+
+	// load the banned tags
+	bannedTags := []map[string]string{}
+	count, err = db.From("banned_tags").Select("tag", "exact", false).ExecuteTo(&bannedTags)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d banned tags in database\n", count)
+	for _, tag := range bannedTags {
+		rules = append(rules, &BanRule{Kind: banExact, Field: fieldTags, Pattern: tag["tag"], CaseSensitive: true, skip: skipBannedTag})
+	}
+
+	// load the banned words
+	bannedWords := []map[string]string{}
+	count, err = db.From("banned_words").Select("word", "exact", false).ExecuteTo(&bannedWords)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d banned words in database\n", count)
+	for _, word := range bannedWords {
+		rules = append(rules, &BanRule{Kind: banSubstring, Field: fieldList, Pattern: word["word"], CaseSensitive: true, skip: skipBannedWord})
+	}
+
+	// a broken rule is a typo in the database, so better to stop than to let everything through
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			log.Fatal("ERR!: BAN RULES:", err)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,310 @@
+// covers.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Covers
+//
+// Book images are links to Audible's CDN, so the site hotlinks them. The
+// covers command is an optional stage that mirrors them locally:
+//
+// 	laud covers [--dir covers] [--sizes 64,160,320] [--refresh]
+//
+// Each cover is stored by the sha256 of its contents, covers/ab/ab12….jpg, so
+// books with the same image share a file, with a JPEG thumbnail of each width
+// alongside it, covers/ab/ab12…-160.jpg. The covers table has a row for each
+// book with the image it came from, its hash and size, its dominant colour,
+// and a 64 bit perceptual hash (a difference hash).
+//
+// Perceptual hashes survive resizing and recompression, so two covers within
+// coverMatchBits bits of each other are the same picture, which suspects uses
+// to spot the same book listed twice. The site uses the local thumbnails, and
+// the dominant colour while they load, when there are any.
+
+// where covers go, unless --dir says otherwise
+const defaultCoversDir = "covers"
+
+// the thumbnail widths the site uses, which should be in --sizes
+const (
+	coverThumbSize = 160
+	coverPageSize  = 320
+)
+
+// perceptual hashes this close are the same picture
+const coverMatchBits = 6
+
+type cover struct {
+	Id        string    `json:"asin"`
+	Url       string    `json:"url"`
+	Hash      string    `json:"sha256"`
+	Format    string    `json:"format"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Colour    string    `json:"colour"`
+	PHash     string    `json:"phash"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// covers/ab/ab12….jpg for the original, covers/ab/ab12…-160.jpg for a thumbnail
+func coverPath(dir, hash, format string, width int) string {
+	name := hash + "." + format
+	if width > 0 {
+		name = fmt.Sprintf("%s-%d.jpg", hash, width)
+	}
+	return filepath.Join(dir, hash[:2], name)
+}
+
+func (c cover) path(dir string, width int) string {
+	ext := c.Format
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	return coverPath(dir, c.Hash, ext, width)
+}
+
+func (c cover) phash() (uint64, bool) {
+	h, err := strconv.ParseUint(c.PHash, 16, 64)
+	return h, err == nil && c.PHash != ""
+}
+
+// whether two covers are the same picture, if we have both
+func sameCover(a, b cover) bool {
+	ha, ok := a.phash()
+	if !ok {
+		return false
+	}
+	hb, ok := b.phash()
+	if !ok {
+		return false
+	}
+	return bits.OnesCount64(ha^hb) <= coverMatchBits
+}
+
+// scales img to width × height, averaging the pixels each one covers
+func resize(img image.Image, width, height int) *image.RGBA {
+	src := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// the same shape, width pixels across
+func thumbnail(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	return resize(img, width, height)
+}
+
+// the most common colour, to 4 bits a channel, as #rrggbb
+func dominantColour(img image.Image) string {
+	small := resize(img, 32, 32)
+	type total struct{ r, g, b, n int }
+	buckets := map[int]*total{}
+	best := -1
+	for i := 0; i < len(small.Pix); i += 4 {
+		r, g, b := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2])
+		key := r>>4<<8 | g>>4<<4 | b>>4
+		t, ok := buckets[key]
+		if !ok {
+			t = &total{}
+			buckets[key] = t
+		}
+		t.r, t.g, t.b, t.n = t.r+r, t.g+g, t.b+b, t.n+1
+		if best < 0 || t.n > buckets[best].n || (t.n == buckets[best].n && key < best) {
+			best = key
+		}
+	}
+	t := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", t.r/t.n, t.g/t.n, t.b/t.n)
+}
+
+// a difference hash: shrink to 9×8 grey, and set a bit wherever a pixel is
+// darker than the one to its right
+func differenceHash(img image.Image) uint64 {
+	small := resize(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if left < right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func fetchCover(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func writeThumbnail(path string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// stores the image under its hash, with its thumbnails, and describes it
+func storeCover(dir, url string, data []byte, sizes []int) (cover, error) {
+	sum := sha256.Sum256(data)
+	c := cover{Url: url, Hash: hex.EncodeToString(sum[:]), FetchedAt: time.Now().UTC()}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return c, err
+	}
+	c.Format = format
+	c.Width = img.Bounds().Dx()
+	c.Height = img.Bounds().Dy()
+	c.Colour = dominantColour(img)
+	c.PHash = fmt.Sprintf("%016x", differenceHash(img))
+
+	original := c.path(dir, 0)
+	if err := os.MkdirAll(filepath.Dir(original), 0755); err != nil {
+		return c, err
+	}
+	if err := os.WriteFile(original, data, 0644); err != nil {
+		return c, err
+	}
+	for _, width := range sizes {
+		if err := writeThumbnail(c.path(dir, width), thumbnail(img, width)); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseSizes(s string) ([]int, error) {
+	sizes := []int{}
+	for _, field := range strings.Split(s, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("bad size '%s'", field)
+		}
+		sizes = append(sizes, width)
+	}
+	return sizes, nil
+}
+
+func loadCovers(db *supabase.Client) map[string]cover {
+	covers := map[string]cover{}
+	err := forEachRow(db, "covers", "asin,url,sha256,format,width,height,colour,phash", func(c cover) {
+		covers[c.Id] = c
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return covers
+}
+
+func (bc *BookCollector) mirrorCovers(args []string) {
+	flags := flag.NewFlagSet("covers", flag.ExitOnError)
+	dir := flags.String("dir", defaultCoversDir, "directory to keep covers in")
+	sizeList := flags.String("sizes", fmt.Sprintf("64,%d,%d", coverThumbSize, coverPageSize), "thumbnail widths")
+	refresh := flags.Bool("refresh", false, "fetch every cover again, not just new and changed ones")
+	flags.Parse(args)
+	sizes, err := parseSizes(*sizeList)
+	if err != nil {
+		log.Fatal("ERR!: COVERS:", err)
+	}
+
+	covers := loadCovers(bc.db)
+	books := []Book{}
+	err = forEachRow(bc.db, "books", "asin,title,image,hidden", func(b Book) {
+		if b.Hidden || b.Image == "" {
+			return
+		}
+		if c, ok := covers[b.Id]; ok && c.Url == b.Image && !*refresh {
+			if _, err := os.Stat(c.path(*dir, 0)); err == nil {
+				return
+			}
+		}
+		books = append(books, b)
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d covers to fetch\n", len(books))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	fetched := 0
+	for _, b := range books {
+		data, err := fetchCover(client, b.Image)
+		if err != nil {
+			log.Printf("- - ERR!: COVER: %s %s", b.Id, err)
+			continue
+		}
+		c, err := storeCover(*dir, b.Image, data, sizes)
+		if err != nil {
+			log.Printf("- - ERR!: COVER: %s %s", b.Id, err)
+			continue
+		}
+		c.Id = b.Id
+		_, _, err = bc.db.From("covers").Upsert(c, "asin", "", "").Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: covers: id:%s %s", b.Id, err)
+			continue
+		}
+		fetched++
+		log.Printf("- • COVER: %s %s %s %s\n", b.Id, c.Colour, c.PHash, b.Title)
+	}
+	log.Printf("COVERS: %d of %d fetched into %s\n", fetched, len(books), *dir)
+}
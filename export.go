@@ -0,0 +1,291 @@
+// export.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Export
+//
+// For spreadsheets and notebooks, the export command writes books, with
+// their tags and scores, as CSV, NDJSON (one JSON object per line) or Parquet:
+//
+// 	laud export --format csv --out books.csv --tag Fantasy --min-rating 4.5
+// 	laud export --format parquet --out books.parquet --columns asin,title,rating,laudscore
+//
+// It takes the same filters as search (see filters.go) and works a page of
+// books at a time, so the whole catalogue never has to be in memory.
+
+type exportColumn struct {
+	name string
+	// parquet type, as used in a parquet-go schema tag
+	parquet string
+	value   func(b *Book, tags []string) interface{}
+}
+
+const (
+	parquetString = "type=BYTE_ARRAY, convertedtype=UTF8"
+	parquetDouble = "type=DOUBLE"
+	parquetInt    = "type=INT64"
+	parquetBool   = "type=BOOLEAN"
+)
+
+var exportColumns = []exportColumn{
+	{"asin", parquetString, func(b *Book, tags []string) interface{} { return b.Id }},
+	{"title", parquetString, func(b *Book, tags []string) interface{} { return b.Title }},
+	{"subtitle", parquetString, func(b *Book, tags []string) interface{} { return b.SubTitle }},
+	{"author", parquetString, func(b *Book, tags []string) interface{} { return b.Author }},
+	{"series", parquetString, func(b *Book, tags []string) interface{} { return b.Series }},
+	{"releasedate", parquetString, func(b *Book, tags []string) interface{} {
+		if b.ReleaseDate.IsZero() {
+			return ""
+		}
+		return b.ReleaseDate.Format(dateFormat)
+	}},
+	{"durationInMins", parquetInt, func(b *Book, tags []string) interface{} { return b.DurationInMins }},
+	{"language", parquetString, func(b *Book, tags []string) interface{} { return b.Language }},
+	{"link", parquetString, func(b *Book, tags []string) interface{} { return b.Link }},
+	{"image", parquetString, func(b *Book, tags []string) interface{} { return b.Image }},
+	{"ratings", parquetInt, func(b *Book, tags []string) interface{} {
+		ns, err := stringsToInts(b.RatingsOverall)
+		if err != nil {
+			return 0
+		}
+		return sum(ns)
+	}},
+	{"rating", parquetDouble, func(b *Book, tags []string) interface{} { return b.Rating }},
+	{"ratingperformance", parquetDouble, func(b *Book, tags []string) interface{} { return b.RatingPerformance }},
+	{"ratingstory", parquetDouble, func(b *Book, tags []string) interface{} { return b.RatingStory }},
+	{"ratinggenre", parquetDouble, func(b *Book, tags []string) interface{} { return b.RatingGenre }},
+	{"popularity", parquetDouble, func(b *Book, tags []string) interface{} { return b.PopularityScore }},
+	{"laudscore", parquetDouble, func(b *Book, tags []string) interface{} { return b.LaudScore }},
+	{"ranker", parquetString, func(b *Book, tags []string) interface{} { return b.Ranker }},
+	{"included_with_membership", parquetBool, func(b *Book, tags []string) interface{} { return b.IncludedMembership }},
+	{"tags", "", func(b *Book, tags []string) interface{} { return tags }},
+}
+
+// the book columns the export columns are made from
+const exportBookColumns = "asin,title,subtitle,author,series,releasedate,durationInMins,language,link,image," +
+	"ratingsoverall,rating,ratingperformance,ratingstory,ratinggenre,popularity,laudscore,ranker,included_with_membership"
+
+func findExportColumns(names string) ([]exportColumn, error) {
+	if names == "" {
+		return exportColumns, nil
+	}
+	columns := []exportColumn{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range exportColumns {
+			if c.name == name {
+				columns = append(columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column '%s'", name)
+		}
+	}
+	return columns, nil
+}
+
+// writes one book at a time, then closes the file off
+type exportWriter interface {
+	Write(b *Book, tags []string) error
+	Close() error
+}
+
+//
+// CSV
+//
+
+type csvExporter struct {
+	columns []exportColumn
+	w       *csv.Writer
+}
+
+func newCSVExporter(columns []exportColumn, w io.Writer) (*csvExporter, error) {
+	e := &csvExporter{columns: columns, w: csv.NewWriter(w)}
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	return e, e.w.Write(header)
+}
+
+func (e *csvExporter) Write(b *Book, tags []string) error {
+	record := make([]string, len(e.columns))
+	for i, c := range e.columns {
+		switch v := c.value(b, tags).(type) {
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []string:
+			record[i] = strings.Join(v, "|")
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+//
+// NDJSON
+//
+
+type ndjsonExporter struct {
+	columns []exportColumn
+	w       *bufio.Writer
+}
+
+func (e *ndjsonExporter) Write(b *Book, tags []string) error {
+	record := map[string]interface{}{}
+	for _, c := range e.columns {
+		record[c.name] = c.value(b, tags)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	e.w.Write(line)
+	return e.w.WriteByte('\n')
+}
+
+func (e *ndjsonExporter) Close() error {
+	return e.w.Flush()
+}
+
+//
+// Parquet
+//
+
+type parquetExporter struct {
+	columns []exportColumn
+	pw      *writer.JSONWriter
+}
+
+// keep row groups small so we don't buffer the whole catalogue
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+func newParquetExporter(columns []exportColumn, w io.Writer) (*parquetExporter, error) {
+	fields := []string{}
+	for _, c := range columns {
+		if c.name == "tags" {
+			fields = append(fields, `{"Tag": "name=tags, type=LIST, repetitiontype=OPTIONAL", "Fields": [{"Tag": "name=element, `+parquetString+`, repetitiontype=REQUIRED"}]}`)
+			continue
+		}
+		fields = append(fields, fmt.Sprintf(`{"Tag": "name=%s, %s, repetitiontype=OPTIONAL"}`, c.name, c.parquet))
+	}
+	schema := `{"Tag": "name=book, repetitiontype=REQUIRED", "Fields": [` + strings.Join(fields, ", ") + `]}`
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetExporter{columns: columns, pw: pw}, nil
+}
+
+func (e *parquetExporter) Write(b *Book, tags []string) error {
+	record := map[string]interface{}{}
+	for _, c := range e.columns {
+		record[c.name] = c.value(b, tags)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return e.pw.Write(string(line))
+}
+
+func (e *parquetExporter) Close() error {
+	return e.pw.WriteStop()
+}
+
+func newExportWriter(format string, columns []exportColumn, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVExporter(columns, w)
+	case "ndjson", "jsonl":
+		return &ndjsonExporter{columns: columns, w: bufio.NewWriter(w)}, nil
+	case "parquet":
+		return newParquetExporter(columns, w)
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+func (bc *BookCollector) export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "csv, ndjson or parquet")
+	out := flags.String("out", "", "file to write to, or standard output")
+	columnNames := flags.String("columns", "", "comma separated columns to export, or all of them")
+	filter := bookFilter{}
+	filter.addFlags(flags)
+	flags.Parse(args)
+
+	columns, err := findExportColumns(*columnNames)
+	if err != nil {
+		log.Fatal("ERR!: EXPORT:", err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal("ERR!: EXPORT:", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	ew, err := newExportWriter(*format, columns, w)
+	if err != nil {
+		log.Fatal("ERR!: EXPORT:", err)
+	}
+
+	if filter.hideOwned {
+		filter.owned = ownedBooks(bc.loadLibrary())
+	}
+	count := 0
+	err = forEachPage(bc.db, "books", exportBookColumns, filter.where, func(books []Book) error {
+		ids := make([]string, len(books))
+		for i, b := range books {
+			ids[i] = b.Id
+		}
+		tags, err := loadTagsFor(bc.db, ids)
+		if err != nil {
+			return err
+		}
+		for i := range books {
+			if !filter.matchLocal(books[i].Id, tags[books[i].Id]) {
+				continue
+			}
+			if err := ew.Write(&books[i], tags[books[i].Id]); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal("ERR!: EXPORT:", err)
+	}
+	if err := ew.Close(); err != nil {
+		log.Fatal("ERR!: EXPORT:", err)
+	}
+	log.Printf("EXPORT: %d books as %s\n", count, *format)
+}
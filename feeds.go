@@ -0,0 +1,250 @@
+// feeds.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Feeds
+//
+// To follow "new Space Opera rated above 4.3" in a feed reader, the site
+// command also writes Atom and RSS feeds of the newest books, by when we
+// first saw them (inserted_at), next to the pages:
+//
+// 	feeds/category/<name>.atom and .rss
+// 	feeds/tag/<name>.atom and .rss
+// 	feeds/author/<name>.atom and .rss
+// 	feeds/query/<name>.atom and .rss
+//
+// Saved queries are rows in saved_queries, with the same filters as search,
+// and are applied to the books on the site. Entries have the cover, the
+// summary, and both the weighted rating and the plain average Audible shows.
+// Set SITE_URL (or --base-url) to where the site will be, so entries link
+// to the book pages, otherwise they link to Audible.
+
+type savedQuery struct {
+	Id             int      `json:"id"`
+	Name           string   `json:"name"`
+	Tags           []string `json:"tags"`
+	MinRating      float64  `json:"min_rating"`
+	MinDuration    int      `json:"min_duration"`
+	MaxDuration    int      `json:"max_duration"`
+	ReleasedAfter  string   `json:"released_after"`
+	ReleasedBefore string   `json:"released_before"`
+	IncludeSuspect bool     `json:"include_suspect"`
+	HideOwned      bool     `json:"hide_owned"`
+	Included       bool     `json:"included_with_membership"`
+}
+
+func (q savedQuery) filter() bookFilter {
+	return bookFilter{
+		tags:           q.Tags,
+		minRating:      q.MinRating,
+		minDuration:    q.MinDuration,
+		maxDuration:    q.MaxDuration,
+		releasedAfter:  q.ReleasedAfter,
+		releasedBefore: q.ReleasedBefore,
+		includeSuspect: q.IncludeSuspect,
+		hideOwned:      q.HideOwned,
+		included:       q.Included,
+	}
+}
+
+func (bc *BookCollector) loadSavedQueries() []savedQuery {
+	queries := []savedQuery{}
+	_, err := bc.db.From("saved_queries").Select("*", "", false).Order("name", ascending).ExecuteTo(&queries)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return queries
+}
+
+// where a page and its feeds go, e.g. tag/space-opera
+func feedPath(kind, name string) string {
+	return kind + "/" + slug(name)
+}
+
+//
+// Atom
+//
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	Id        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Links     []atomLink `xml:"link"`
+	Author    string     `xml:"author>name"`
+	Content   atomText   `xml:"content"`
+}
+
+//
+// RSS
+//
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type feedWriter struct {
+	dir     string
+	baseURL string
+	size    int
+	now     time.Time
+	entry   *template.Template
+}
+
+func newFeedWriter(dir, baseURL string, size int, now time.Time) *feedWriter {
+	if baseURL != "" && !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &feedWriter{
+		dir:     dir,
+		baseURL: baseURL,
+		size:    size,
+		now:     now,
+		entry:   parseSiteTemplates(baseURL, "entry.html"),
+	}
+}
+
+// the book's page on the site, if we know where that is
+func (fw *feedWriter) bookLink(b *siteBook) string {
+	if fw.baseURL == "" {
+		return b.Link
+	}
+	return fw.baseURL + "book/" + b.Id + ".html"
+}
+
+// the newest books first
+func newestBooks(books []*siteBook, n int) []*siteBook {
+	newest := append([]*siteBook{}, books...)
+	sort.SliceStable(newest, func(i, j int) bool { return newest[i].InsertedAt.After(newest[j].InsertedAt) })
+	if n > 0 && len(newest) > n {
+		newest = newest[:n]
+	}
+	return newest
+}
+
+func (fw *feedWriter) write(title, path string, books []*siteBook) error {
+	books = newestBooks(books, fw.size)
+	updated := fw.now
+	if len(books) > 0 {
+		updated = books[0].InsertedAt
+	}
+	pageLink := ""
+	feedId := "urn:laud:" + path
+	if fw.baseURL != "" {
+		pageLink = fw.baseURL + path + ".html"
+		feedId = pageLink
+	}
+
+	atom := atomFeed{
+		Title:   title,
+		Id:      feedId,
+		Updated: updated.UTC().Format(time.RFC3339),
+	}
+	if fw.baseURL != "" {
+		atom.Links = []atomLink{
+			{Href: pageLink, Rel: "alternate", Type: "text/html"},
+			{Href: fw.baseURL + "feeds/" + path + ".atom", Rel: "self", Type: "application/atom+xml"},
+		}
+	}
+	rss := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:         title,
+		Link:          pageLink,
+		Description:   "New audiobooks: " + title,
+		LastBuildDate: fw.now.UTC().Format(time.RFC1123Z),
+	}}
+
+	for _, b := range books {
+		var content bytes.Buffer
+		if err := fw.entry.ExecuteTemplate(&content, "entry", b); err != nil {
+			return err
+		}
+		link := fw.bookLink(b)
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:     b.Title,
+			Id:        "urn:asin:" + b.Id,
+			Updated:   b.InsertedAt.UTC().Format(time.RFC3339),
+			Published: b.InsertedAt.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Href: link, Rel: "alternate", Type: "text/html"}},
+			Author:    b.Author,
+			Content:   atomText{Type: "html", Body: content.String()},
+		})
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       b.Title,
+			Link:        link,
+			Description: content.String(),
+			Guid:        rssGuid{IsPermaLink: false, Id: "urn:asin:" + b.Id},
+			PubDate:     b.InsertedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	if err := writeXML(filepath.Join(fw.dir, path+".atom"), atom); err != nil {
+		return err
+	}
+	return writeXML(filepath.Join(fw.dir, path+".rss"), rss)
+}
+
+func writeXML(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := xml.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), out...), 0644)
+}
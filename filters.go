@@ -0,0 +1,153 @@
+// filters.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/supabase/postgrest-go"
+)
+
+// Book filters
+//
+// The same filters as search, shared by anything that picks books out of the
+// catalogue (export, the site, feeds), so they all take the same flags:
+//
+// 	--tag Fantasy --tag "Space Opera"   must have every tag
+// 	--min-rating 4.3                     weighted rating
+// 	--min-duration 300 --max-duration 1200   in minutes
+// 	--released-after 2020-01-01 --released-before 2024-01-01
+// 	--include-suspect                    suspect books are left out by default
+// 	--hide-owned                         leave out books in our library
+// 	--included                           only books included with membership
+//
+// Hidden books are always left out.
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type bookFilter struct {
+	tags           stringList
+	minRating      float64
+	minDuration    int
+	maxDuration    int
+	releasedAfter  string
+	releasedBefore string
+	includeSuspect bool
+	hideOwned      bool
+	included       bool
+	// the asins in our library, for hideOwned
+	owned map[string]bool
+}
+
+func (f *bookFilter) addFlags(flags *flag.FlagSet) {
+	flags.Var(&f.tags, "tag", "only books with this tag, can be repeated")
+	flags.Float64Var(&f.minRating, "min-rating", 0, "only books with at least this weighted rating")
+	flags.IntVar(&f.minDuration, "min-duration", 0, "only books at least this many minutes long")
+	flags.IntVar(&f.maxDuration, "max-duration", 0, "only books at most this many minutes long")
+	flags.StringVar(&f.releasedAfter, "released-after", "", "only books released on or after this date (YYYY-MM-DD)")
+	flags.StringVar(&f.releasedBefore, "released-before", "", "only books released on or before this date (YYYY-MM-DD)")
+	flags.BoolVar(&f.includeSuspect, "include-suspect", false, "include books flagged as suspect")
+	flags.BoolVar(&f.hideOwned, "hide-owned", false, "leave out books in the library")
+	flags.BoolVar(&f.included, "included", false, "only books included with membership")
+}
+
+// the filters the database can do for us
+//
+// postgrest-go keeps one filter per column, so a --max-duration would replace
+// a --min-duration. The ranges go together as a single and(…) instead, which
+// it can only send inside an or.
+func (f *bookFilter) where(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	q = q.Eq("hidden", "false")
+	if !f.includeSuspect {
+		q = q.Eq("suspect", "false")
+	}
+	if f.included {
+		q = q.Eq("included_with_membership", "true")
+	}
+	if f.minRating > 0 {
+		q = q.Gte("rating", fmt.Sprint(f.minRating))
+	}
+	ranges := []string{}
+	if f.minDuration > 0 {
+		ranges = append(ranges, fmt.Sprintf("durationInMins.gte.%d", f.minDuration))
+	}
+	if f.maxDuration > 0 {
+		ranges = append(ranges, fmt.Sprintf("durationInMins.lte.%d", f.maxDuration))
+	}
+	if f.releasedAfter != "" {
+		ranges = append(ranges, "releasedate.gte."+f.releasedAfter)
+	}
+	if f.releasedBefore != "" {
+		ranges = append(ranges, "releasedate.lte."+f.releasedBefore)
+	}
+	if len(ranges) > 0 {
+		q = q.Or("and("+strings.Join(ranges, ",")+")", "")
+	}
+	return q
+}
+
+// the filters the database can't do for us, tags come from the tags table
+// and ownership from the library
+func (f *bookFilter) matchLocal(id string, tags []string) bool {
+	if f.hideOwned && f.owned[id] {
+		return false
+	}
+	return f.matchTags(tags)
+}
+
+func (f *bookFilter) matchTags(tags []string) bool {
+	for _, want := range f.tags {
+		found := false
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// the same filters, for books we already have in memory
+func (f *bookFilter) match(b *Book, tags []string) bool {
+	if b.Hidden || (b.Suspect && !f.includeSuspect) {
+		return false
+	}
+	if f.included && !b.IncludedMembership {
+		return false
+	}
+	if f.minRating > 0 && b.Rating < f.minRating {
+		return false
+	}
+	if f.minDuration > 0 && b.DurationInMins < f.minDuration {
+		return false
+	}
+	if f.maxDuration > 0 && b.DurationInMins > f.maxDuration {
+		return false
+	}
+	released := ""
+	if !b.ReleaseDate.IsZero() {
+		released = b.ReleaseDate.Format(dateFormat)
+	}
+	if f.releasedAfter != "" && (released == "" || released < f.releasedAfter) {
+		return false
+	}
+	if f.releasedBefore != "" && (released == "" || released > f.releasedBefore) {
+		return false
+	}
+	return f.matchLocal(b.Id, tags)
+}
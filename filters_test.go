@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// the query string where sends, as postgrest sees it
+func whereQuery(t *testing.T, f bookFilter) url.Values {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	db, err := supabase.NewClient(server.URL, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []Book{}
+	if _, err := f.where(db.From("books").Select("asin", "", false)).ExecuteTo(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func TestWhereSendsBothBounds(t *testing.T) {
+	f := bookFilter{minDuration: 300, maxDuration: 1200, releasedAfter: "2020-01-01", releasedBefore: "2024-01-01"}
+	query := whereQuery(t, f)
+	or := query.Get("or")
+	for _, want := range []string{"durationInMins.gte.300", "durationInMins.lte.1200", "releasedate.gte.2020-01-01", "releasedate.lte.2024-01-01"} {
+		if !strings.Contains(or, want) {
+			t.Errorf("or=%s, want it to have %s", or, want)
+		}
+	}
+	if !strings.HasPrefix(or, "(and(") {
+		t.Errorf("or=%s, want a single and(…)", or)
+	}
+	for _, column := range []string{"durationInMins", "releasedate"} {
+		if query.Has(column) {
+			t.Errorf("%s=%s, want the range only in the and(…)", column, query.Get(column))
+		}
+	}
+	if query.Get("hidden") != "eq.false" || query.Get("suspect") != "eq.false" {
+		t.Errorf("hidden=%s suspect=%s, want both eq.false", query.Get("hidden"), query.Get("suspect"))
+	}
+}
+
+func TestWhereWithoutRanges(t *testing.T) {
+	if query := whereQuery(t, bookFilter{}); query.Has("or") {
+		t.Errorf("or=%s, want no or with no ranges", query.Get("or"))
+	}
+}
+
+func TestMatchBothBounds(t *testing.T) {
+	f := bookFilter{minDuration: 300, maxDuration: 1200, releasedAfter: "2020-01-01", releasedBefore: "2024-01-01"}
+	released := func(s string) Date {
+		d, err := time.Parse(dateFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Date{d}
+	}
+	tests := []struct {
+		name     string
+		duration int
+		released Date
+		want     bool
+	}{
+		{"inside", 600, released("2022-06-01"), true},
+		{"on the bounds", 300, released("2020-01-01"), true},
+		{"on the upper bounds", 1200, released("2024-01-01"), true},
+		{"too short", 299, released("2022-06-01"), false},
+		{"too long", 1201, released("2022-06-01"), false},
+		{"too early", 600, released("2019-12-31"), false},
+		{"too late", 600, released("2024-01-02"), false},
+		{"no release date", 600, Date{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Book{Id: "B0TEST", DurationInMins: tt.duration, ReleaseDate: tt.released}
+			if got := f.match(b, nil); got != tt.want {
+				t.Errorf("match(%d mins, %s) = %v, want %v", tt.duration, b.ReleaseDate.Format(dateFormat), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// languages.go
+
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Languages
+//
+// List items say 'Language: English' on the UK store, but 'Sprache: Deutsch'
+// on the German one, 'Langue : Français' on the French one and so on. We match
+// the label for any store, then turn whatever it says into one English name so
+// the same search can show the English and German editions side by side.
+//
+// Which languages we keep is set in .env, e.g.
+//
+// 	LANGUAGES=English,German
+//
+// and defaults to English.
+
+const defaultLanguages = "English"
+
+var findLanguageRx = regexp.MustCompile(`(?i)(?:Language|Sprache|Langue|Idioma|Lingua|Taal)\s*:\s*(\p{L}+)`)
+
+// localised language names, lower case, to the name we store
+var languageNames = map[string]string{
+	"english": "English", "englisch": "English", "anglais": "English", "inglés": "English", "inglese": "English", "engels": "English",
+	"german": "German", "deutsch": "German", "allemand": "German", "alemán": "German", "tedesco": "German", "duits": "German",
+	"french": "French", "französisch": "French", "français": "French", "francés": "French", "francese": "French", "frans": "French",
+	"spanish": "Spanish", "spanisch": "Spanish", "espagnol": "Spanish", "español": "Spanish", "spagnolo": "Spanish", "spaans": "Spanish",
+	"italian": "Italian", "italienisch": "Italian", "italien": "Italian", "italiano": "Italian", "italiaans": "Italian",
+	"dutch": "Dutch", "niederländisch": "Dutch", "néerlandais": "Dutch", "neerlandés": "Dutch", "olandese": "Dutch", "nederlands": "Dutch",
+}
+
+// finds the language label in some text and returns the language's English
+// name, or what the label said if we don't know it, or "" if there's no label
+func findLanguage(text string) string {
+	m := findLanguageRx.FindStringSubmatch(text)
+	if len(m) == 0 {
+		return ""
+	}
+	if name, ok := languageNames[strings.ToLower(m[1])]; ok {
+		return name
+	}
+	return m[1]
+}
+
+// LANGUAGES from .env, as a fast lookup
+func loadLanguages() map[string]bool {
+	setting := os.Getenv("LANGUAGES")
+	if setting == "" {
+		setting = defaultLanguages
+	}
+	languages := map[string]bool{}
+	for _, language := range strings.Split(setting, ",") {
+		language = strings.TrimSpace(language)
+		if name, ok := languageNames[strings.ToLower(language)]; ok {
+			language = name
+		}
+		if language != "" {
+			languages[language] = true
+		}
+	}
+	log.Printf("INFO: languages: %s\n", setting)
+	return languages
+}
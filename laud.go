@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -182,6 +183,7 @@ const (
 	sortFeatured Sort = "" // seems to produce guff!
 	sortPop      Sort = "popularity-rank"
 	sortReview   Sort = "review-rank"
+	sortNewest   Sort = "pubdate-desc-rank" // just for crawl --new
 )
 
 var sorts []Sort = []Sort{
@@ -198,6 +200,8 @@ func (s Sort) Friendly() string {
 		return "Popularity"
 	case sortReview:
 		return "Review Score"
+	case sortNewest:
+		return "Release Date"
 	}
 	return "Unknown Sort"
 }
@@ -214,17 +218,17 @@ func makeSearchUrl(n Category, s Sort, page int) string {
 	return url
 }
 
-func stringsToInts(ss []string) []int {
+func stringsToInts(ss []string) ([]int, error) {
 	ns := len(ss)
 	ints := make([]int, ns)
 	for i := 0; i < ns; i++ {
 		n, err := strconv.Atoi(strings.ReplaceAll(ss[i], ",", ""))
 		if err != nil {
-			log.Fatal("ERR!: stringsToInts:", err)
+			return nil, fmt.Errorf("stringsToInts: %w", err)
 		}
 		ints[i] = n
 	}
-	return ints
+	return ints, nil
 }
 
 //
@@ -238,8 +242,9 @@ type Book struct {
 	AuthorLink         string    `json:"authorlink" selector:".authorLabel > a" attr:"href"`
 	Series             string    `json:"series" selector:".seriesLabel > a"`
 	SeriesLink         string    `json:"serieslink" selector:".seriesLabel > a" attr:"href"`
+	SeriesPosition     float64   `json:"seriesposition,omitempty"`
 	Format             string    `json:"format" selector:".format"`
-	ReleaseDate        time.Time `json:"releasedate"`
+	ReleaseDate        Date      `json:"releasedate"`
 	Image              string    `json:"image" selector:"#center-1 .bc-col-3 > div > div:nth-child(1) > img" attr:"src"`
 	Sample             string    `json:"sample" selector:"[id*=sample-player] > button" attr:"data-mp3"`
 	Id                 string    `json:"asin" selector:"[id*=sample-player] > button" attr:"sample-asin"`
@@ -255,6 +260,16 @@ type Book struct {
 	RatingStory        float64   `json:"ratingstory"`
 	DurationInMins     int       `json:"durationInMins"`
 	PopularityScore    float64   `json:"popularity"`
+	Language           string    `json:"language" selector:".languageLabel"`
+	Ranker             string    `json:"ranker"`
+	Category           Category  `json:"category"`
+	RatingGenre        float64   `json:"ratinggenre,omitempty"`
+	RatingGenrePrior   string    `json:"ratinggenreprior,omitempty"`
+	LaudScore          float64   `json:"laudscore"`
+	LaudScoreExplain   laudParts `json:"laudscoreexplain,omitempty"`
+	Hidden             bool      `json:"hidden"`
+	Suspect            bool      `json:"suspect"`
+	IncludedMembership bool      `json:"included_with_membership"`
 }
 
 type tag struct {
@@ -264,19 +279,32 @@ type tag struct {
 
 type BookCollector struct {
 	books           map[string]bool
-	bannedTags      map[string]bool
-	bannedWords     []string
+	hidden          map[string]bool
+	banRules        banRules
+	priceAlerts     map[string]*priceAlert
+	lastPrices      map[string]priceSnapshot
+	membership      map[string]bool
+	languages       map[string]bool
+	ranker          Ranker
 	db              *supabase.Client
 	listCollector   *colly.Collector
 	detailCollector *colly.Collector
+	discoverDepth   int
+	splitSearches   bool
+	onlyNew         bool
+	skipped         map[string]bool
+	pageNew         int
+	currentSource   ListSource
+	currentRank     int
 	currentCategory Category
 	currentSort     Sort
 	popularityScore float64
+	popChanged      map[string]bool
 }
 
-var inEnglishRx = regexp.MustCompile(`(?i)Language:\s+English`)
 var findPreOrderRx = regexp.MustCompile(`(?i)pre-?order`)
 var findNotRatedRx = regexp.MustCompile(`(?i)Not\srated\syet`)
+var findReleaseDateRx = regexp.MustCompile(`(?i)Release date:\s*(\d{2}-\d{2}-\d{4})`)
 var fixFormatRx = regexp.MustCompile(`\s+`)
 var findMinRx = regexp.MustCompile(`(?i)(\d+)M`)
 var findHourRx = regexp.MustCompile(`(?i)(\d+)H`)
@@ -304,36 +332,80 @@ func (bc *BookCollector) setupCollectors() {
 		// scraping the actual text in the DOM is quicker and easier than looking
 		// in the html attributes for these values (it's probably less brittle too)
 		productText := e.DOM.Text()
-		// is this book in English?
-		// 'Language: English'
-		if !inEnglishRx.MatchString(productText) {
-			log.Println("- - SKIP: NOT ENGLISH:", title)
+		// remember where we saw it, even if we skip it
+		bc.currentRank++
+		if id != "" && !bc.books[id] && !bc.skipped[id] {
+			bc.pageNew++
+		}
+		bc.addSightingToDB(id)
+		bc.addSeriesEntryToDB(id, title, productText)
+		// is this book in a language we want?
+		// 'Language: English', 'Sprache: Deutsch' etc.
+		// if there's no label we don't know, so let the product page decide
+		language := findLanguage(productText)
+		if language != "" && !bc.languages[language] {
+			log.Printf("- - SKIP: LANGUAGE '%s': %s", language, title)
+			bc.addSkippedBookToDB(id, title, skipLanguage, language)
 			return
 		}
 		// is this book pre-order only?
 		// 'pre-order'
 		if findPreOrderRx.MatchString(productText) {
 			log.Println("- - SKIP: PRE-ORDER:", title)
+			bc.addSkippedBookToDB(id, title, skipPreOrder, "")
+			// keep an eye on it so we can pick it up once it's released
+			bc.addPreOrderToDB(id, title, findReleaseDate(productText))
 			return
 		}
 		// has this book been rated yet?
 		// 'Not rated yet'
 		if findNotRatedRx.MatchString(productText) {
 			log.Println("- - SKIP: NOT RATED:", title)
+			bc.addSkippedBookToDB(id, title, skipNotRated, "")
+			// try again once it's had time to collect some ratings
+			bc.addRevisitToDB(id, title)
 			return
 		}
-		// does this book contain banned words?
-		// banned words are stored in database and loaded on launch
-		for _, bannedWord := range bc.bannedWords {
-			if strings.Contains(productText, bannedWord) {
-				log.Printf("- - SKIP: word '%s' in %s", bannedWord, title)
-				return
-			}
+		// does this book break any ban rules?
+		// ban rules are stored in database and loaded on launch
+		author := ""
+		e.ForEachWithBreak(".authorLabel a", func(_ int, h *colly.HTMLElement) bool {
+			author = h.Text
+			return false
+		})
+		series := ""
+		e.ForEachWithBreak(".seriesLabel a", func(_ int, h *colly.HTMLElement) bool {
+			series = h.Text
+			return false
+		})
+		fields := banFields{
+			fieldTitle:  {title},
+			fieldAuthor: {author},
+			fieldSeries: {series},
+			fieldText:   {productText},
+			fieldList:   {productText},
+			fieldAsin:   {id},
+		}
+		if rule, matched := bc.banRules.match(fields); rule != nil {
+			log.Printf("- - SKIP: %s '%s' in %s", rule.Field, matched, title)
+			bc.addSkippedBookToDB(id, title, rule.skip, rule.describe(matched))
+			return
 		}
+		// keep track of how fast the ratings are arriving
+		bc.addRatingHistoryToDB(id, productText)
+		// and of the prices, which fires any price alerts
+		bc.addPriceHistoryToDB(id, title, productText)
+		// and whether it's included with membership, which comes and goes
+		included := findIncludedRx.MatchString(productText)
+		bc.updateMembership(id, title, included)
+
 		// tag it as we've now seen it in this category, even if we've already seen it in another
 		// audible don't put categories in metadata, but we need them there for search
-		for _, tag := range bc.currentCategory.Tags() {
-			bc.addBookTagToDB(id, tag)
+		// unless we've hidden it, which took its tags away
+		if !bc.hidden[id] {
+			for _, tag := range bc.currentCategory.Tags() {
+				bc.addBookTagToDB(id, tag)
+			}
 		}
 
 		// have we fetched this book before?
@@ -346,8 +418,12 @@ func (bc *BookCollector) setupCollectors() {
 			return
 		}
 		// not in the map so go and fetch it
+		// passing on what we found out here that isn't on the product page
 		link := baseBookUrl + id
-		bc.detailCollector.Visit(e.Request.AbsoluteURL(link))
+		ctx := colly.NewContext()
+		ctx.Put("language", language)
+		ctx.Put("included", strconv.FormatBool(included))
+		bc.detailCollector.Request("GET", e.Request.AbsoluteURL(link), nil, ctx, nil)
 	})
 
 	//
@@ -360,18 +436,44 @@ func (bc *BookCollector) setupCollectors() {
 		b := &Book{}
 		e.Unmarshal(b)
 
-		// does this book contain banned tags?
-		for _, tag := range b.Tags {
-			if _, ok := bc.bannedTags[tag]; ok {
-				log.Printf("- - SKIP: tag '%s' in %s", tag, b.Title)
-				return
-			}
+		// is this book in a language we want?
+		// we can get here without the list filters, e.g. from a revisit
+		if language := e.Request.Ctx.Get("language"); language != "" {
+			b.Language = language
+		} else {
+			b.Language = findLanguage(b.Language)
+		}
+		// no label means we don't know, which isn't a reason to throw it away
+		if b.Language != "" && !bc.languages[b.Language] {
+			log.Printf("- - SKIP: LANGUAGE '%s': %s", b.Language, b.Title)
+			bc.addSkippedBookToDB(b.Id, b.Title, skipLanguage, b.Language)
+			return
+		}
+		// the list page told us this too, product pages don't say
+		b.IncludedMembership = e.Request.Ctx.Get("included") == "true"
+
+		// keep the carousels of related books, whatever happens to this one
+		relations := findRelations(b.Id, e)
+		bc.addRelationsToDB(b.Id, relations)
+
+		// discovered books are this many steps from a list, 0 if they're on one
+		depth, _ := strconv.Atoi(e.Request.Ctx.Get("depth"))
+
+		// has this book been rated yet?
+		// we can get here without the list filters, e.g. from a revisit
+		if len(b.RatingsOverall) == 0 {
+			log.Println("- - SKIP: NO RATINGS:", b.Title)
+			bc.addSkippedBookToDB(b.Id, b.Title, skipNotRated, "")
+			bc.addRevisitToDB(b.Id, b.Title)
+			return
 		}
 
 		// fix a few things
 
 		b.Format = fixFormatRx.ReplaceAllString(b.Format, " ")
 		b.Link = baseBookUrl + b.Id
+		// 'Series: The Stormlight Archive, Book 1'
+		b.SeriesPosition = findSeriesPosition(e.ChildText(".seriesLabel"))
 
 		e.ForEachWithBreak("#center-9 > div > div > div:nth-child(2) > span", func(_ int, h *colly.HTMLElement) bool {
 			html, err := h.DOM.Html()
@@ -381,14 +483,27 @@ func (bc *BookCollector) setupCollectors() {
 			b.Summary = html
 			return false
 		})
+
+		// does this book break any ban rules?
+		// now we have tags, series and summary
+		if rule, matched := bc.banRules.match(bookBanFields(b)); rule != nil {
+			log.Printf("- - SKIP: %s '%s' in %s", rule.Field, matched, b.Title)
+			bc.addSkippedBookToDB(b.Id, b.Title, rule.skip, rule.describe(matched))
+			return
+		}
+
 		if len(b.RatingsOverall) > 0 {
-			b.Rating = starSort(stringsToInts(b.RatingsOverall))
+			b.Rating = bc.rank(b.Id, b.RatingsOverall)
 		}
 		if len(b.RatingsPerformance) > 0 {
-			b.RatingPerformance = starSort(stringsToInts(b.RatingsPerformance))
+			b.RatingPerformance = bc.rank(b.Id, b.RatingsPerformance)
 		}
 		if len(b.RatingsStory) > 0 {
-			b.RatingStory = starSort(stringsToInts(b.RatingsStory))
+			b.RatingStory = bc.rank(b.Id, b.RatingsStory)
+		}
+		b.Ranker = bc.ranker.Name()
+		if depth == 0 {
+			b.Category = bc.currentCategory
 		}
 
 		// pull data from javascript json
@@ -409,7 +524,7 @@ func (bc *BookCollector) setupCollectors() {
 				if err != nil {
 					log.Println("- - ERR!: Could not parse time:", err)
 				}
-				b.ReleaseDate = datePublished
+				b.ReleaseDate = Date{datePublished}
 			} else {
 				log.Println("ERR!: skipping datePublished")
 			}
@@ -446,7 +561,14 @@ func (bc *BookCollector) setupCollectors() {
 
 		// as this is the first time we've seen this book
 		// we can calculate it's base popularity
-		b.PopularityScore = bc.getNextPopularityScore(bc.currentSort)
+		if depth == 0 {
+			b.PopularityScore = bc.getNextPopularityScore(bc.currentSort)
+		}
+		b.LaudScore, b.LaudScoreExplain = laudScore(b, time.Now())
+
+		// and the top reviews, which we only keep for books we keep
+		reviews, total := findReviews(b.Id, e)
+		bc.addReviewsToDB(b.Id, reviews, total)
 
 		// add to books
 		bc.books[b.Id] = true
@@ -462,8 +584,8 @@ func (bc *BookCollector) setupCollectors() {
 			log.Printf("- • BOOK: %s (%1.2f★) %s, by %s\n", b.Id, b.Rating, b.Title, b.Author)
 			_, _, err = bc.db.From("books").Insert(b, false, "", "", "").Execute()
 			if err != nil {
-				log.Println("ERR!: DATABASE: id:%s", err, b.Id)
-				log.Println("%#v", b)
+				log.Printf("ERR!: DATABASE: id:%s %s", b.Id, err)
+				log.Printf("%#v", b)
 			}
 		} else {
 			// we still need to update popularity score
@@ -473,22 +595,59 @@ func (bc *BookCollector) setupCollectors() {
 
 			log.Printf("- - DUP!: %s (%d)", b.Id, count)
 		}
+
+		// follow the carousels, if we're discovering
+		bc.discover(relations, depth)
 	})
 }
 
-func (bc *BookCollector) getAllPages(category Category, sort Sort) {
+// score a scraped histogram with the current ranker
+func (bc *BookCollector) rank(id string, ratings []string) float64 {
+	return bc.rankWith(bc.ranker, id, ratings)
+}
+
+// a broken histogram shouldn't stop the crawl, so it just scores zero
+func (bc *BookCollector) rankWith(ranker Ranker, id string, ratings []string) float64 {
+	ns, err := stringsToInts(ratings)
+	if err == nil {
+		var score float64
+		score, err = ranker.Rank(ns)
+		if err == nil {
+			return score
+		}
+	}
+	log.Printf("- - ERR!: could not rank %s %v: %s", id, ratings, err)
+	return 0
+}
+
+func (bc *BookCollector) getAllPages(source ListSource) {
 	// setupCollectors needs these, so pass them in bc
-	bc.currentCategory = category
-	bc.currentSort = sort
+	bc.currentSource = source
+	bc.currentRank = 0
+	bc.currentCategory = source.Category()
+	bc.currentSort = source.Sort()
 	bc.popularityScore = popularityTopScore // give points to the top 250 or so
 
 	// page numbers start at 1 hence the (pageNumber-1)*pageSize)+1
-	for pageNumber := 1; pageNumber <= pagesToFetch; pageNumber++ {
-		log.Printf("- PAGE: %d of %d (books: %d to %d) (%s by %s)\n", pageNumber, pagesToFetch, ((pageNumber-1)*pageSize)+1, pageNumber*pageSize, category.Friendly(), sort.Friendly())
+	pages := source.Pages()
+	for pageNumber := 1; pageNumber <= pages; pageNumber++ {
+		log.Printf("- PAGE: %d of %d (books: %d to %d) (%s)\n", pageNumber, pages, ((pageNumber-1)*pageSize)+1, pageNumber*pageSize, source.Friendly())
 
-		url := makeSearchUrl(category, sort, pageNumber)
+		url := source.URL(pageNumber)
 		log.Println("- - LOAD:", url)
+		seen := bc.currentRank
+		bc.pageNew = 0
 		bc.listCollector.Visit(url)
+		// most lists run out well before the last page
+		if bc.currentRank == seen {
+			log.Println("- - END: no books on page", pageNumber)
+			break
+		}
+		// newest first, so once a page has nothing new nor will the rest
+		if bc.onlyNew && bc.pageNew == 0 {
+			log.Println("- - END: nothing new on page", pageNumber)
+			break
+		}
 	}
 }
 
@@ -498,6 +657,15 @@ func (bc *BookCollector) getDebugPage(url string) {
 	bc.listCollector.Visit(url)
 }
 
+func (bc *BookCollector) visitBook(id string) bool {
+	// load a single product page, skipping the list page filters,
+	// and report whether the book made it through the detail filters
+	link := baseBookUrl + id
+	log.Println("- - LOAD:", link)
+	bc.detailCollector.Visit(link)
+	return bc.books[id]
+}
+
 func (bc *BookCollector) addBookTagToDB(id, tag string) {
 	// insert_tag RPC
 	bc.db.Rpc("insert_tag", "", map[string]string{"asin": id, "tag": tag})
@@ -519,12 +687,91 @@ func (bc *BookCollector) getNextPopularityScore(sort Sort) float64 {
 func (bc *BookCollector) updatePopularityScoreInDB(id string, score float64) {
 	// add_to_popularity_score RPC
 	bc.db.Rpc("add_to_popularity_score", "", map[string]interface{}{"asin": id, "score": score})
+	// so its laud score can catch up at the end of the crawl
+	bc.popChanged[id] = true
+}
+
+// commands are picked by the first argument, crawl is the default.
+// each gets the remaining arguments so it can parse its own flags.
+var commands = map[string]func(bc *BookCollector, args []string){
+	"crawl":          func(bc *BookCollector, args []string) { bc.crawl(args) },
+	"preorders":      func(bc *BookCollector, args []string) { bc.fetchReleasedPreOrders() },
+	"revisit":        func(bc *BookCollector, args []string) { bc.revisitUnrated() },
+	"reapply-bans":   func(bc *BookCollector, args []string) { bc.reapplyBans(args) },
+	"priors":         func(bc *BookCollector, args []string) { bc.estimatePriors() },
+	"rescore":        func(bc *BookCollector, args []string) { bc.rescore(args) },
+	"suspects":       func(bc *BookCollector, args []string) { bc.findSuspects(args) },
+	"export":         func(bc *BookCollector, args []string) { bc.export(args) },
+	"site":           func(bc *BookCollector, args []string) { bc.site(args) },
+	"import-library": func(bc *BookCollector, args []string) { bc.importLibrary(args) },
+	"price-alert":    func(bc *BookCollector, args []string) { bc.setPriceAlert(args) },
+	"series-gaps":    func(bc *BookCollector, args []string) { bc.seriesGaps(args) },
+	"recommend":      func(bc *BookCollector, args []string) { bc.recommend(args) },
+	"covers":         func(bc *BookCollector, args []string) { bc.mirrorCovers(args) },
+}
+
+func (bc *BookCollector) crawl(args []string) {
+	flags := flag.NewFlagSet("crawl", flag.ExitOnError)
+	sourceNames := stringList{}
+	flags.Var(&sourceNames, "source", "search, bestsellers, new-releases, authors, series, saved or a list_sources name, can be repeated")
+	flags.IntVar(&bc.discoverDepth, "discover", 0, "also fetch related books from product pages, this many steps away")
+	flags.BoolVar(&bc.splitSearches, "partition", true, "split searches with more results than we can read into smaller ones, which takes many more requests")
+	flags.BoolVar(&bc.onlyNew, "new", false, "just the new releases in each category, stopping at a page of books we know")
+	flags.Parse(args)
+	if len(sourceNames) == 0 {
+		// load category list, once for each sort
+		sourceNames = stringList{"search"}
+	}
+
+	var sources []ListSource
+	if bc.onlyNew {
+		// books we've skipped are known too, or we'd never stop
+		bc.skipped = loadSkippedIds(bc.db)
+		for _, category := range categories {
+			sources = append(sources, categorySearch{category, sortNewest})
+		}
+	} else {
+		sources = bc.listSources(sourceNames)
+	}
+
+	for i, source := range sources {
+		// read through the products
+		log.Printf("SOURCE: %s", source.Friendly())
+		bc.getAllPages(source)
+
+		// tell the database to update all the tags
+		// update_all_tags RPC
+		// only categories add tags, and a category's sources come together
+		// (its searches, and their slices), so once they're all read
+		done := i == len(sources)-1 || sources[i+1].Category() != source.Category()
+		if source.Category() != "" && done {
+			log.Println("TAGS: update:", bc.db.Rpc("update_all_tags", "", nil))
+		}
+	}
+
+	// popularity has moved, so the laud scores have too
+	ids := []string{}
+	for id := range bc.popChanged {
+		ids = append(ids, id)
+	}
+	bc.updateLaudScores(ids)
 }
 
 func main() {
 
 	log.Printf("Laudible v%f\n", version)
 
+	commandName := "crawl"
+	args := []string{}
+	if len(os.Args) > 1 {
+		commandName = os.Args[1]
+		args = os.Args[2:]
+	}
+	command, ok := commands[commandName]
+	if !ok {
+		log.Fatalf("ERR!: unknown command '%s'", commandName)
+	}
+
 	// loaded by magic. well, actually:
 	// github.com/joho/godotenv/autoload
 	API_URL := os.Getenv("API_URL")
@@ -547,8 +794,8 @@ func main() {
 	// initialise the book collector
 	bookCollector := BookCollector{
 		books:           map[string]bool{},
-		bannedTags:      map[string]bool{},
-		bannedWords:     []string{},
+		popChanged:      map[string]bool{},
+		languages:       loadLanguages(),
 		db:              SbClient,
 		listCollector:   listCollector,
 		detailCollector: detailCollector,
@@ -563,45 +810,28 @@ func main() {
 	}
 	log.Printf("INFO: %d books in database\n", count)
 
-	// load the banned tags
-	bannedTags := []map[string]string{}
-	count, err = SbClient.From("banned_tags").Select("tag", "exact", false).ExecuteTo(&bannedTags)
-	if err != nil {
-		log.Fatal("ERR!: DATABASE:", err)
-	}
-	log.Printf("INFO: %d banned tags in database\n", count)
+	// load the ranker, which might need the books for its prior
+	bookCollector.ranker = loadRanker(SbClient)
 
-	// load the banned words
-	bannedWords := []map[string]string{}
-	count, err = SbClient.From("banned_words").Select("word", "exact", false).ExecuteTo(&bannedWords)
-	if err != nil {
-		log.Fatal("ERR!: DATABASE:", err)
-	}
-	log.Printf("INFO: %d banned words in database\n", count)
+	// load the ban rules, including the old banned tags and words
+	bookCollector.banRules = loadBanRules(SbClient)
+
+	// and the price alerts, which are checked as we go
+	bookCollector.priceAlerts = loadPriceAlerts(SbClient)
+
+	// and the last prices we saw, so we only keep the changes
+	bookCollector.lastPrices = loadLastPrices(SbClient)
+
+	// and which books are included with membership, so we can see when that changes
+	bookCollector.membership = loadMembership(SbClient)
+
+	// and which of them are hidden, so they stay out of the tags
+	bookCollector.hidden = loadHidden(SbClient)
 
 	// convert books to a fast asin lookup
 	for _, book := range allKnownIds {
 		bookCollector.books[book["asin"]] = true
 	}
-	// convert banned_tags to a fast tag lookup
-	for _, tag := range bannedTags {
-		bookCollector.bannedTags[tag["tag"]] = true
-	}
-	// convert banned_tags to a fast tag lookup
-	for _, word := range bannedWords {
-		bookCollector.bannedWords = append(bookCollector.bannedWords, word["word"])
-	}
-
-	// load category list, once for each sort
-	for _, category := range categories {
-		for _, sort := range sorts {
-			// read through the products
-			log.Printf("CATEGORY: %s sorted by %s", category.Friendly(), sort.Friendly())
-			bookCollector.getAllPages(category, sort)
 
-			// tell the database to update all the tags
-			// update_all_tags RPC
-			log.Println("TAGS: update:", SbClient.Rpc("update_all_tags", "", nil))
-		}
-	}
+	command(&bookCollector, args)
 }
@@ -0,0 +1,135 @@
+// laudscore.go
+
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Laud score
+//
+// We rank by rating or by popularity, but they're on different scales (0–5
+// and 0–500ish) so there's no sensible way to sort by both. The laud score
+// squashes each of these onto 0–1 and takes a weighted average, out of 100:
+//
+// 	rating        weighted rating, 1★ = 0, 5★ = 1
+// 	story         weighted story rating, the same
+// 	performance   weighted performance rating, the same
+// 	popularity    popularity out of 500
+// 	ratings       number of ratings, on a log scale where LAUD_RATINGS_SCALE ratings = 1
+// 	recency       halves every LAUD_HALF_LIFE_DAYS since release
+//
+// The weights are set in .env, e.g. LAUD_WEIGHT_RATING=0.4, and only matter
+// relative to each other. Alongside the score we store an explanation of how
+// much each component contributed, so it's possible to see why a book is
+// where it is.
+//
+// The score is worked out when a book is added, but popularity keeps adding
+// up as we see the book in other lists, so a crawl remembers the books whose
+// popularity it changed and scores them again at the end.
+
+type laudComponent struct {
+	Name   string
+	Weight float64
+}
+
+var laudComponents = []laudComponent{
+	{"rating", envFloat("LAUD_WEIGHT_RATING", 0.4)},
+	{"story", envFloat("LAUD_WEIGHT_STORY", 0.1)},
+	{"performance", envFloat("LAUD_WEIGHT_PERFORMANCE", 0.1)},
+	{"popularity", envFloat("LAUD_WEIGHT_POPULARITY", 0.2)},
+	{"ratings", envFloat("LAUD_WEIGHT_RATINGS", 0.1)},
+	{"recency", envFloat("LAUD_WEIGHT_RECENCY", 0.1)},
+}
+
+var laudRatingsScale = envFloat("LAUD_RATINGS_SCALE", 10000)
+var laudHalfLifeDays = envFloat("LAUD_HALF_LIFE_DAYS", 365)
+
+type laudContribution struct {
+	Value        float64 `json:"value"`        // as stored
+	Normalised   float64 `json:"normalised"`   // 0–1
+	Weight       float64 `json:"weight"`       // share of the total weight
+	Contribution float64 `json:"contribution"` // points out of 100
+}
+
+// the explanation, by component name
+type laudParts map[string]laudContribution
+
+func clamp01(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+// the rating columns are 0 when there's no histogram, which shouldn't count as 1★
+func normaliseStars(rating float64) float64 {
+	if rating == 0 {
+		return 0
+	}
+	return clamp01((rating - 1) / 4)
+}
+
+func laudScore(b *Book, now time.Time) (float64, laudParts) {
+	ratings := 0
+	if ns, err := stringsToInts(b.RatingsOverall); err == nil {
+		ratings = sum(ns)
+	}
+	age := 0.0
+	recency := 0.0
+	if !b.ReleaseDate.IsZero() {
+		age = math.Max(0, now.Sub(b.ReleaseDate.Time).Hours()/24)
+		recency = math.Pow(0.5, age/laudHalfLifeDays)
+	}
+	values := map[string][2]float64{
+		"rating":      {b.Rating, normaliseStars(b.Rating)},
+		"story":       {b.RatingStory, normaliseStars(b.RatingStory)},
+		"performance": {b.RatingPerformance, normaliseStars(b.RatingPerformance)},
+		"popularity":  {b.PopularityScore, clamp01(b.PopularityScore / 500)},
+		"ratings":     {float64(ratings), clamp01(math.Log1p(float64(ratings)) / math.Log1p(laudRatingsScale))},
+		"recency":     {age, recency},
+	}
+
+	totalWeight := 0.0
+	for _, c := range laudComponents {
+		totalWeight += c.Weight
+	}
+	if totalWeight == 0 {
+		return 0, nil
+	}
+	score := 0.0
+	explain := laudParts{}
+	for _, c := range laudComponents {
+		v := values[c.Name]
+		weight := c.Weight / totalWeight
+		contribution := 100 * weight * v[1]
+		score += contribution
+		explain[c.Name] = laudContribution{Value: v[0], Normalised: v[1], Weight: weight, Contribution: contribution}
+	}
+	return score, explain
+}
+
+// works out the laud score again for books whose popularity has changed
+func (bc *BookCollector) updateLaudScores(ids []string) {
+	scores := []map[string]interface{}{}
+	now := time.Now()
+	columns := "asin,releasedate,popularity,rating,ratingstory,ratingperformance,ratingsoverall"
+	for from := 0; from < len(ids); from += dbLookupSize {
+		to := from + dbLookupSize
+		if to > len(ids) {
+			to = len(ids)
+		}
+		books := []Book{}
+		_, err := bc.db.From("books").Select(columns, "", false).In("asin", ids[from:to]).ExecuteTo(&books)
+		if err != nil {
+			log.Printf("ERR!: DATABASE: books: %s", err)
+			continue
+		}
+		for i := range books {
+			score := map[string]interface{}{"asin": books[i].Id}
+			score["laudscore"], score["laudscoreexplain"] = laudScore(&books[i], now)
+			scores = append(scores, score)
+		}
+	}
+	log.Printf("LAUD: re-scoring %d books whose popularity changed\n", len(scores))
+	bc.updateBookScores(scores)
+}
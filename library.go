@@ -0,0 +1,312 @@
+// library.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Library
+//
+// There's no point recommending books we already own, so import-library
+// records what's in our own library, in the library table, from one of:
+//
+// 	laud import-library --format audible library.html     # a saved copy of the Audible library page
+// 	laud import-library --format goodreads goodreads.csv  # Goodreads' "Export Library" CSV
+// 	laud import-library --format asins asins.txt          # one ASIN (or Audible link) per line
+//
+// Each book is owned, finished and/or has a personal rating, 0 for none.
+// Imports add to what's already there, so importing the Audible library and
+// then Goodreads gives books that are both owned and rated. Goodreads doesn't
+// know ASINs, so its books are matched to ours by author and title, and
+// anything we don't have is skipped.
+//
+// The filters take --hide-owned, to leave owned books out.
+
+type libraryBook struct {
+	Id             string    `json:"asin"`
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	Owned          bool      `json:"owned"`
+	Finished       bool      `json:"finished"`
+	PersonalRating float64   `json:"personal_rating"`
+	Source         string    `json:"source"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// the two imports together, keeping what either knows
+func (l libraryBook) merge(other libraryBook) libraryBook {
+	if l.Title == "" {
+		l.Title = other.Title
+	}
+	if l.Author == "" {
+		l.Author = other.Author
+	}
+	l.Owned = l.Owned || other.Owned
+	l.Finished = l.Finished || other.Finished
+	if other.PersonalRating > 0 {
+		l.PersonalRating = other.PersonalRating
+	}
+	if other.Source != "" && !strings.Contains(l.Source, other.Source) {
+		if l.Source != "" {
+			l.Source += ","
+		}
+		l.Source += other.Source
+	}
+	return l
+}
+
+var findASINRx = regexp.MustCompile(`\b(B0[0-9A-Z]{8}|[0-9]{9}[0-9X])\b`)
+
+//
+// Audible
+//
+// the library page has a row per book, with its asin in the id. Finished
+// books have a visible 'Finished' in place of the time remaining.
+//
+
+func readAudibleLibrary(r io.Reader) ([]libraryBook, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	books := []libraryBook{}
+	doc.Find(`div[id^="adbl-library-content-row-"]`).Each(func(i int, row *goquery.Selection) {
+		id, _ := row.Attr("id")
+		id = strings.TrimPrefix(id, "adbl-library-content-row-")
+		if !findASINRx.MatchString(id) {
+			return
+		}
+		b := libraryBook{
+			Id:     id,
+			Title:  strings.TrimSpace(row.Find(".bc-size-headline3").First().Text()),
+			Author: strings.TrimSpace(row.Find(".authorLabel a").First().Text()),
+			Owned:  true,
+			Source: "audible",
+		}
+		finished := row.Find(`[id^="time-remaining-finished"]`).First()
+		b.Finished = finished.Length() > 0 && !finished.HasClass("bc-pub-hidden")
+		if stars, ok := row.Find(".adbl-prod-rate-review-bar-overall").Attr("data-star-count"); ok {
+			b.PersonalRating, _ = strconv.ParseFloat(stars, 64)
+		}
+		books = append(books, b)
+	})
+	if len(books) == 0 {
+		return nil, fmt.Errorf("no books found, is this a saved Audible library page?")
+	}
+	return books, nil
+}
+
+//
+// Goodreads
+//
+
+type goodreadsBook struct {
+	Title       string
+	Author      string
+	Rating      float64
+	Shelf       string
+	OwnedCopies int
+	HasDateRead bool
+}
+
+func readGoodreadsCSV(r io.Reader) ([]goodreadsBook, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	column := map[string]int{}
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	for _, name := range []string{"Title", "Author", "My Rating", "Exclusive Shelf"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("no '%s' column, is this a Goodreads export?", name)
+		}
+	}
+	get := func(record []string, name string) string {
+		i, ok := column[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	books := []goodreadsBook{}
+	for _, record := range records[1:] {
+		b := goodreadsBook{
+			Title:       get(record, "Title"),
+			Author:      get(record, "Author"),
+			Shelf:       get(record, "Exclusive Shelf"),
+			HasDateRead: get(record, "Date Read") != "",
+		}
+		b.Rating, _ = strconv.ParseFloat(get(record, "My Rating"), 64)
+		b.OwnedCopies, _ = strconv.Atoi(get(record, "Owned Copies"))
+		books = append(books, b)
+	}
+	return books, nil
+}
+
+var seriesSuffixRx = regexp.MustCompile(`\s*\([^)]*#[^)]*\)\s*$`)
+
+// names differ in spacing and punctuation, "J.R.R. Tolkien" vs "J. R. R. Tolkien"
+func authorKey(author string) string {
+	return strings.Join(titleWordsRx.FindAllString(strings.ToLower(author), -1), "")
+}
+
+// the closest title by the same author, if it's close enough
+func (bc *BookCollector) matchGoodreads(rows []goodreadsBook) []libraryBook {
+	byAuthor := map[string][]Book{}
+	err := forEachRow(bc.db, "books", "asin,title,author", func(b Book) {
+		byAuthor[authorKey(b.Author)] = append(byAuthor[authorKey(b.Author)], b)
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	books := []libraryBook{}
+	for _, row := range rows {
+		// Goodreads puts the series on the end, "(The Stormlight Archive, #1)"
+		words := titleWords(seriesSuffixRx.ReplaceAllString(row.Title, ""))
+		var best *Book
+		bestScore := 0.0
+		candidates := byAuthor[authorKey(row.Author)]
+		for i := range candidates {
+			if score := jaccard(words, titleWords(candidates[i].Title)); score > bestScore {
+				best, bestScore = &candidates[i], score
+			}
+		}
+		if best == nil || bestScore < 0.75 {
+			log.Printf("- - SKIP: not found: %s, by %s\n", row.Title, row.Author)
+			continue
+		}
+		books = append(books, libraryBook{
+			Id:             best.Id,
+			Title:          best.Title,
+			Author:         best.Author,
+			Owned:          row.OwnedCopies > 0,
+			Finished:       row.Shelf == "read" || row.HasDateRead,
+			PersonalRating: row.Rating,
+			Source:         "goodreads",
+		})
+	}
+	return books
+}
+
+//
+// ASIN list
+//
+
+func readASINList(r io.Reader, finished bool) ([]libraryBook, error) {
+	books := []libraryBook{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id := findASINRx.FindString(line)
+		if id == "" {
+			log.Printf("- - SKIP: no ASIN in '%s'\n", line)
+			continue
+		}
+		books = append(books, libraryBook{Id: id, Owned: true, Finished: finished, Source: "asins"})
+	}
+	return books, scanner.Err()
+}
+
+// the library, by asin
+func (bc *BookCollector) loadLibrary() map[string]libraryBook {
+	library := map[string]libraryBook{}
+	err := forEachRow(bc.db, "library", "asin,title,author,owned,finished,personal_rating,source", func(l libraryBook) {
+		library[l.Id] = l
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return library
+}
+
+func ownedBooks(library map[string]libraryBook) map[string]bool {
+	owned := map[string]bool{}
+	for id, l := range library {
+		if l.Owned {
+			owned[id] = true
+		}
+	}
+	return owned
+}
+
+func (bc *BookCollector) importLibrary(args []string) {
+	flags := flag.NewFlagSet("import-library", flag.ExitOnError)
+	format := flags.String("format", "asins", "audible, goodreads or asins")
+	finished := flags.Bool("finished", false, "mark every book in an ASIN list as finished")
+	dryRun := flags.Bool("dry-run", false, "show what would be imported without saving it")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("ERR!: IMPORT: which file? laud import-library --format audible|goodreads|asins file")
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal("ERR!: IMPORT:", err)
+	}
+	defer f.Close()
+
+	var books []libraryBook
+	switch *format {
+	case "audible":
+		books, err = readAudibleLibrary(f)
+	case "goodreads":
+		var rows []goodreadsBook
+		rows, err = readGoodreadsCSV(f)
+		books = bc.matchGoodreads(rows)
+	case "asins":
+		books, err = readASINList(f, *finished)
+	default:
+		err = fmt.Errorf("unknown format '%s'", *format)
+	}
+	if err != nil {
+		log.Fatal("ERR!: IMPORT:", err)
+	}
+
+	library := bc.loadLibrary()
+	now := time.Now().UTC()
+	rows := []libraryBook{}
+	// a book can be in the file more than once, and postgres won't upsert a
+	// row twice in one go, so later copies are merged into the first one's row
+	row := map[string]int{}
+	for _, b := range books {
+		l := library[b.Id].merge(b)
+		l.Id = b.Id
+		l.UpdatedAt = now
+		library[b.Id] = l
+		if i, ok := row[b.Id]; ok {
+			rows[i] = l
+		} else {
+			row[b.Id] = len(rows)
+			rows = append(rows, l)
+		}
+		log.Printf("- LIBRARY: %s owned:%t finished:%t rating:%g %s\n", l.Id, l.Owned, l.Finished, l.PersonalRating, l.Title)
+	}
+	log.Printf("IMPORT: %d books from %s\n", len(rows), *format)
+	if *dryRun || len(rows) == 0 {
+		return
+	}
+	_, _, err = bc.db.From("library").Upsert(rows, "asin", "", "").Execute()
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+}
@@ -0,0 +1,67 @@
+// membership.go
+
+package main
+
+import (
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Included with membership
+//
+// Some titles are included with membership, at no extra credit, and these
+// come and go. List pages say 'Included in membership', so every time we see
+// a book we check, and when it changes we update included_with_membership on
+// books and add a row to membership_history, so we know when it was in and
+// out. The filters take --included to only show these, and the site has a
+// leaderboard of them.
+//
+// Books we only see on their product page (revisits, pre-orders) don't say,
+// and are left as not included until they turn up on a list.
+
+var findIncludedRx = regexp.MustCompile(`(?i)\bincluded\s+(?:in|with)\s+(?:your\s+)?(?:audible\s+)?(?:premium\s+)?(?:plus|membership)\b`)
+
+type membershipChange struct {
+	Id       string    `json:"asin"`
+	Included bool      `json:"included"`
+	SeenAt   time.Time `json:"seen_at"`
+}
+
+// whether each book was included last time we saw it, from its latest
+// membership_history row, as we see (and record) plenty that aren't in books
+func loadMembership(db *supabase.Client) map[string]bool {
+	membership := map[string]bool{}
+	// forEachRow goes up through the ids within each asin, so the latest wins
+	err := forEachRow(db, "membership_history", "asin,included,seen_at", func(c membershipChange) {
+		membership[c.Id] = c.Included
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return membership
+}
+
+func (bc *BookCollector) updateMembership(id, title string, included bool) {
+	if was, ok := bc.membership[id]; ok && was == included {
+		return
+	}
+	bc.membership[id] = included
+	if included {
+		log.Println("- • INCLUDED WITH MEMBERSHIP:", title)
+	}
+	_, _, err := bc.db.From("membership_history").Insert(membershipChange{Id: id, Included: included, SeenAt: time.Now().UTC()}, false, "", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: membership_history: id:%s %s", id, err)
+	}
+	// new books get it when they're added
+	if !bc.books[id] {
+		return
+	}
+	_, _, err = bc.db.From("books").Update(map[string]interface{}{"included_with_membership": included}, "", "").Eq("asin", id).Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: books: id:%s %s", id, err)
+	}
+}
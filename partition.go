@@ -0,0 +1,285 @@
+// partition.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+)
+
+// Partitioned searches
+//
+// A search only shows the first pageSize × pagesToFetch (500) results, so big
+// categories like Fantasy are cut short. When a category has more than that,
+// it's split into slices using the filters down the side of the search page:
+// release date, length, price and so on. Each filter link is the same search
+// with one more parameter, so we don't need to know Audible's parameters, we
+// just read them off the page.
+//
+// For each filter we count the results of every value, and use the first one
+// whose values add up to (nearly) the whole search, so the slices between
+// them cover the category. Slices still over the cap are split again by
+// another filter, up to partitionDepth filters deep.
+//
+// Sub-categories aren't used: the menus link to every category, so they
+// can't be told apart from the filters, and the ones we want are already in
+// categories.
+//
+// The slices are extra sources alongside the category's own searches, which
+// still score popularity. They're sorted by review, and books in more than
+// one slice are only fetched once, as with any other list.
+//
+// It costs a request for every filter value we count, and then up to
+// pagesToFetch pages for each slice, so crawl --partition=false turns it off.
+
+// the most results a search will show
+const searchCap = pageSize * pagesToFetch
+
+// the most filters in one slice
+const partitionDepth = 3
+
+// a filter's values must add up to at least this much of the search
+const partitionCoverage = 0.98
+
+// '1 - 50 of 12,345 results'
+var findResultsCountRx = regexp.MustCompile(`(?i)of\s+([\d,]+)\s+results`)
+
+// search parameters that don't filter anything
+var unfilteredParams = map[string]bool{"sort": true, "page": true, "pageSize": true, "ref": true, "keywords": true}
+
+// a category search narrowed by some filters, e.g. &publication_date=…
+type searchSlice struct {
+	category Category
+	params   url.Values
+	labels   []string
+}
+
+func (s searchSlice) Name() string {
+	return fmt.Sprintf("search/%s/%s", s.category, s.params.Encode())
+}
+
+func (s searchSlice) Friendly() string {
+	return fmt.Sprintf("%s (%s)", s.category.Friendly(), strings.Join(s.labels, ", "))
+}
+
+func (s searchSlice) Category() Category { return s.category }
+func (s searchSlice) Sort() Sort         { return sortReview }
+func (s searchSlice) Pages() int         { return pagesToFetch }
+
+func (s searchSlice) URL(page int) string {
+	return makeSearchUrl(s.category, s.Sort(), page) + "&" + s.params.Encode()
+}
+
+// the search with no sort or page, for counting
+func (s searchSlice) probeURL() string {
+	u := baseSearchUrl + "&node=" + string(s.category)
+	if len(s.params) > 0 {
+		u += "&" + s.params.Encode()
+	}
+	return u
+}
+
+func (s searchSlice) with(key, value, label string) searchSlice {
+	params := url.Values{}
+	for k, vs := range s.params {
+		params[k] = append([]string{}, vs...)
+	}
+	params.Set(key, value)
+	return searchSlice{category: s.category, params: params, labels: append(append([]string{}, s.labels...), label)}
+}
+
+type facetValue struct {
+	value string
+	label string
+}
+
+// a filter down the side of the search page, by its parameter
+type facet struct {
+	key    string
+	values []facetValue
+}
+
+type searchProbe struct {
+	count  int
+	facets []facet
+}
+
+// what a filter link adds to the search it's on, if it's a filter link,
+// which keeps the category and all the other filters
+func addedParam(current url.Values, href string) (string, string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || !strings.HasSuffix(u.Path, "/search") {
+		return "", "", false
+	}
+	query := u.Query()
+	for k := range current {
+		if query.Get(k) != current.Get(k) {
+			return "", "", false
+		}
+	}
+	key, value := "", ""
+	for k, vs := range query {
+		if unfilteredParams[k] || strings.HasPrefix(k, "pf_rd_") || len(vs) == 0 || vs[0] == "" {
+			continue
+		}
+		if current.Get(k) == vs[0] {
+			continue
+		}
+		// more than one change isn't a single filter
+		if key != "" {
+			return "", "", false
+		}
+		key, value = k, vs[0]
+	}
+	return key, value, key != ""
+}
+
+// the label without the count some filters show, 'Fantasy (1,234)'
+var facetLabelCountRx = regexp.MustCompile(`\s*\([\d,]+\)\s*$`)
+
+// reads the results count and filter links from a search page
+func readSearchProbe(doc *goquery.Selection, current url.Values) searchProbe {
+	p := searchProbe{count: -1}
+	if m := findResultsCountRx.FindStringSubmatch(doc.Text()); len(m) > 0 {
+		p.count, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	} else if n := doc.Find(".productListItem").Length(); n < pageSize {
+		// everything fits on one page
+		p.count = n
+	}
+	keys := []string{}
+	byKey := map[string]*facet{}
+	seen := map[string]bool{}
+	doc.Find(`a[href*="/search?"]`).Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		key, value, ok := addedParam(current, href)
+		if !ok || seen[key+"="+value] {
+			return
+		}
+		seen[key+"="+value] = true
+		f, ok := byKey[key]
+		if !ok {
+			f = &facet{key: key}
+			byKey[key] = f
+			keys = append(keys, key)
+		}
+		label := facetLabelCountRx.ReplaceAllString(strings.Join(strings.Fields(a.Text()), " "), "")
+		f.values = append(f.values, facetValue{value: value, label: label})
+	})
+	// in the order they're on the page
+	for _, key := range keys {
+		p.facets = append(p.facets, *byKey[key])
+	}
+	return p
+}
+
+// counts the results of a slice, and finds its filters
+func (bc *BookCollector) probeSearch(s searchSlice) searchProbe {
+	p := searchProbe{count: -1}
+	current := url.Values{"node": {string(s.category)}}
+	for k, vs := range s.params {
+		current[k] = vs
+	}
+	c := bc.listCollector.Clone()
+	c.OnHTML("body", func(e *colly.HTMLElement) {
+		p = readSearchProbe(e.DOM, current)
+	})
+	if err := c.Visit(s.probeURL()); err != nil {
+		log.Printf("- - ERR!: PARTITION: %s %s", s.probeURL(), err)
+	}
+	return p
+}
+
+// the slices of a search that each fit under the cap, or just the search if
+// it can't be split any further
+func (bc *BookCollector) partition(s searchSlice, p searchProbe, depth int) []searchSlice {
+	if p.count <= searchCap {
+		return []searchSlice{s}
+	}
+	if depth >= partitionDepth {
+		log.Printf("- - PARTITION: %s still has %d results\n", s.Friendly(), p.count)
+		return []searchSlice{s}
+	}
+
+	var best []searchSlice
+	var bestProbes []searchProbe
+	bestCoverage := 0.0
+	for _, f := range p.facets {
+		if s.params.Get(f.key) != "" {
+			continue
+		}
+		slices := []searchSlice{}
+		probes := []searchProbe{}
+		total := 0
+		narrows := false
+		for _, v := range f.values {
+			slice := s.with(f.key, v.value, v.label)
+			probe := bc.probeSearch(slice)
+			if probe.count == 0 {
+				continue
+			}
+			// we can't count it, but it's still a slice
+			if probe.count > 0 {
+				total += probe.count
+			}
+			if probe.count != p.count {
+				narrows = true
+			}
+			slices = append(slices, slice)
+			probes = append(probes, probe)
+		}
+		// Audible ignores parameters it doesn't know
+		if !narrows {
+			log.Printf("- - PARTITION: ignoring %s, it doesn't narrow the search\n", f.key)
+			continue
+		}
+		coverage := float64(total) / float64(p.count)
+		log.Printf("- - PARTITION: %s by %s: %d slices, %.0f%% of %d results\n", s.Friendly(), f.key, len(slices), coverage*100, p.count)
+		if coverage > bestCoverage {
+			best, bestProbes, bestCoverage = slices, probes, coverage
+		}
+		if coverage >= partitionCoverage {
+			break
+		}
+	}
+	if best == nil {
+		log.Printf("- - PARTITION: no way to split %s (%d results)\n", s.Friendly(), p.count)
+		return []searchSlice{s}
+	}
+	if bestCoverage < partitionCoverage {
+		log.Printf("- - PARTITION: only %.0f%% of %s is in the slices\n", bestCoverage*100, s.Friendly())
+	}
+
+	slices := []searchSlice{}
+	for i, slice := range best {
+		slices = append(slices, bc.partition(slice, bestProbes[i], depth+1)...)
+	}
+	return slices
+}
+
+// the extra sources for a category with more results than a search shows
+func (bc *BookCollector) searchSlices(category Category) []ListSource {
+	whole := searchSlice{category: category, params: url.Values{}}
+	p := bc.probeSearch(whole)
+	if p.count < 0 {
+		log.Printf("INFO: could not count the results for %s\n", category.Friendly())
+		return nil
+	}
+	if p.count <= searchCap {
+		return nil
+	}
+	sources := []ListSource{}
+	for _, slice := range bc.partition(whole, p, 0) {
+		if len(slice.params) > 0 {
+			sources = append(sources, slice)
+		}
+	}
+	log.Printf("INFO: %s has %d results, split into %d searches\n", category.Friendly(), p.count, len(sources))
+	return sources
+}
@@ -0,0 +1,107 @@
+// preorders.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Pre-order watchlist
+//
+// Pre-order titles are skipped by the list collector, as they have no ratings
+// and may never be released, so a big upcoming release would only turn up if
+// it happened to rank in a later crawl.
+//
+// Instead, we note them in the preorders table with their expected release
+// date. The preorders command (meant to be run daily from cron) fetches the
+// product page of everything that should now be out and puts it through the
+// normal detail pipeline.
+//
+// Pre-orders without a release date are fetched once they've been waiting
+// preOrderUndatedDays. Either way each is only fetched once: if it still has
+// no ratings the detail pipeline queues a revisit, and if it's filtered out
+// it's in skipped_books like any other.
+
+// days to wait for a pre-order with no release date
+const preOrderUndatedDays = 30
+
+type preOrder struct {
+	Id          string   `json:"asin"`
+	Title       string   `json:"title"`
+	Category    Category `json:"category"`
+	ReleaseDate *Date    `json:"releasedate,omitempty"`
+}
+
+// list pages show the release date as 'Release date: 24-10-2024'
+func findReleaseDate(productText string) *Date {
+	m := findReleaseDateRx.FindStringSubmatch(productText)
+	if len(m) == 0 {
+		return nil
+	}
+	releaseDate, err := time.Parse("02-01-2006", m[1])
+	if err != nil {
+		log.Println("- - ERR!: Could not parse release date:", err)
+		return nil
+	}
+	return &Date{releaseDate}
+}
+
+func (bc *BookCollector) addPreOrderToDB(id, title string, releaseDate *Date) {
+	if id == "" {
+		return
+	}
+	p := preOrder{
+		Id:          id,
+		Title:       title,
+		Category:    bc.currentCategory,
+		ReleaseDate: releaseDate,
+	}
+	_, _, err := bc.db.From("preorders").Upsert(p, "asin", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: preorders: id:%s %s", id, err)
+	}
+}
+
+func (bc *BookCollector) fetchReleasedPreOrders() {
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	undated := now.AddDate(0, 0, -preOrderUndatedDays).Format(time.RFC3339)
+	due := []preOrder{}
+	count, err := bc.db.From("preorders").
+		Select("asin,title,category,releasedate", "exact", false).
+		Is("released_at", "null").
+		Or(fmt.Sprintf("releasedate.lte.%s,and(releasedate.is.null,inserted_at.lte.%s)", today, undated), "").
+		ExecuteTo(&due)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("PRE-ORDERS: %d released since they were seen\n", count)
+
+	for _, p := range due {
+		bc.currentCategory = p.Category
+		// either way we're done with it, the detail pipeline has queued a
+		// revisit if it's not rated yet, or noted why it was skipped
+		if bc.visitBook(p.Id) {
+			log.Println("- • RELEASED:", p.Id, p.Title)
+			// tag it with the category we first saw it in, as the list
+			// collector would have, now it's in books
+			for _, tag := range p.Category.Tags() {
+				bc.addBookTagToDB(p.Id, tag)
+			}
+		} else {
+			log.Println("- - SKIP: PRE-ORDER NOT ADDED:", p.Title)
+		}
+		_, _, err := bc.db.From("preorders").
+			Update(map[string]interface{}{"released_at": time.Now().UTC()}, "", "").
+			Eq("asin", p.Id).
+			Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: preorders: id:%s %s", p.Id, err)
+		}
+	}
+
+	// tell the database to update all the tags
+	log.Println("TAGS: update:", bc.db.Rpc("update_all_tags", "", nil))
+}
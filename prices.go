@@ -0,0 +1,216 @@
+// prices.go
+
+package main
+
+import (
+	"flag"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Prices
+//
+// List pages show the regular price, and sometimes a member or sale price,
+// e.g. 'Regular price: £19.99 Sale price: £3.99'. Every time we see a book we
+// compare them with the last ones we kept, and when they've changed we add
+// them, with the currency, to price_history, so it has a row for each change.
+//
+// Price alerts are rows in price_alerts, one per book, set with:
+//
+// 	laud price-alert B0XXXXXXXX --below 5 --on-sale
+// 	laud price-alert B0XXXXXXXX --remove
+//
+// An alert fires, once, when the book is seen for less than its target or on
+// sale. It's logged as ALERT: and marked with fired_at and fired_price, and
+// fires again if the price drops further, or after it's gone back up.
+
+var findPriceRx = regexp.MustCompile(`(?i)(regular|list|member|sale|offer)\s+price:?\s*(?:([£$€])\s?([\d.,]+)|([\d.,]+)\s?([£$€]))`)
+
+var currencies = map[string]string{
+	"£": "GBP",
+	"$": "USD",
+	"€": "EUR",
+}
+
+type priceSnapshot struct {
+	Id          string    `json:"asin"`
+	Currency    string    `json:"currency"`
+	ListPrice   float64   `json:"list_price,omitempty"`
+	MemberPrice float64   `json:"member_price,omitempty"`
+	SalePrice   float64   `json:"sale_price,omitempty"`
+	SeenAt      time.Time `json:"seen_at"`
+}
+
+// the lowest price anyone can pay
+func (p priceSnapshot) price() float64 {
+	lowest := p.ListPrice
+	for _, price := range []float64{p.MemberPrice, p.SalePrice} {
+		if price > 0 && (lowest == 0 || price < lowest) {
+			lowest = price
+		}
+	}
+	return lowest
+}
+
+func (p priceSnapshot) onSale() bool {
+	return p.SalePrice > 0 && (p.ListPrice == 0 || p.SalePrice < p.ListPrice)
+}
+
+// 1,234.56 or, in the European stores, 1.234,56
+func parseAmount(s string) (float64, error) {
+	s = strings.Trim(s, ".,")
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && s[i] == ',' && len(s)-i-1 == 2 {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+}
+
+func findPrices(productText string) (priceSnapshot, bool) {
+	p := priceSnapshot{}
+	found := false
+	for _, m := range findPriceRx.FindAllStringSubmatch(productText, -1) {
+		symbol, amount := m[2], m[3]
+		if symbol == "" {
+			symbol, amount = m[5], m[4]
+		}
+		price, err := parseAmount(amount)
+		if err != nil {
+			continue
+		}
+		p.Currency = currencies[symbol]
+		found = true
+		switch strings.ToLower(m[1]) {
+		case "regular", "list":
+			p.ListPrice = price
+		case "member":
+			p.MemberPrice = price
+		case "sale", "offer":
+			p.SalePrice = price
+		}
+	}
+	return p, found
+}
+
+// the same prices, whenever we saw them
+func (p priceSnapshot) same(q priceSnapshot) bool {
+	return p.Currency == q.Currency && p.ListPrice == q.ListPrice && p.MemberPrice == q.MemberPrice && p.SalePrice == q.SalePrice
+}
+
+// the last prices we kept for each book
+func loadLastPrices(db *supabase.Client) map[string]priceSnapshot {
+	prices := map[string]priceSnapshot{}
+	// forEachRow goes up through the ids within each asin, so the newest is
+	// last, and wins
+	err := forEachRow(db, "price_history", "asin,currency,list_price,member_price,sale_price,seen_at", func(p priceSnapshot) {
+		prices[p.Id] = p
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return prices
+}
+
+func (bc *BookCollector) addPriceHistoryToDB(id, title, productText string) {
+	p, ok := findPrices(productText)
+	if id == "" || !ok {
+		return
+	}
+	p.Id = id
+	p.SeenAt = time.Now().UTC()
+	// alerts still need checking, they may have been set since
+	defer bc.checkPriceAlert(title, p)
+	if last, ok := bc.lastPrices[id]; ok && last.same(p) {
+		return
+	}
+	bc.lastPrices[id] = p
+	_, _, err := bc.db.From("price_history").Insert(p, false, "", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: price_history: id:%s %s", id, err)
+	}
+}
+
+type priceAlert struct {
+	Id         string     `json:"asin"`
+	Below      float64    `json:"below"`
+	OnSale     bool       `json:"on_sale"`
+	FiredAt    *time.Time `json:"fired_at"`
+	FiredPrice float64    `json:"fired_price"`
+}
+
+func (bc *BookCollector) checkPriceAlert(title string, p priceSnapshot) {
+	alert, ok := bc.priceAlerts[p.Id]
+	if !ok {
+		return
+	}
+	price := p.price()
+	triggered := (alert.Below > 0 && price > 0 && price < alert.Below) || (alert.OnSale && p.onSale())
+	update := map[string]interface{}{}
+	switch {
+	case !triggered && alert.FiredAt != nil:
+		// it's gone back up, so the next drop fires again
+		alert.FiredAt = nil
+		update["fired_at"] = nil
+		update["fired_price"] = nil
+	case triggered && (alert.FiredAt == nil || price < alert.FiredPrice):
+		now := time.Now().UTC()
+		alert.FiredAt, alert.FiredPrice = &now, price
+		update["fired_at"] = now
+		update["fired_price"] = price
+		log.Printf("ALERT: PRICE: %s %.2f %s (regular %.2f) %s", p.Id, price, p.Currency, p.ListPrice, title)
+	default:
+		return
+	}
+	_, _, err := bc.db.From("price_alerts").Update(update, "", "").Eq("asin", p.Id).Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: price_alerts: id:%s %s", p.Id, err)
+	}
+}
+
+func loadPriceAlerts(db *supabase.Client) map[string]*priceAlert {
+	alerts := map[string]*priceAlert{}
+	err := forEachRow(db, "price_alerts", "asin,below,on_sale,fired_at,fired_price", func(a priceAlert) {
+		alerts[a.Id] = &a
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d price alerts\n", len(alerts))
+	return alerts
+}
+
+func (bc *BookCollector) setPriceAlert(args []string) {
+	flags := flag.NewFlagSet("price-alert", flag.ExitOnError)
+	below := flags.Float64("below", 0, "alert when the price drops below this")
+	onSale := flags.Bool("on-sale", false, "alert when it goes on sale")
+	remove := flags.Bool("remove", false, "remove the alert")
+	// the asin comes first, so parse the flags after it
+	if len(args) == 0 || !findASINRx.MatchString(args[0]) {
+		log.Fatal("ERR!: PRICE ALERT: which book? laud price-alert ASIN [--below price] [--on-sale] [--remove]")
+	}
+	id := args[0]
+	flags.Parse(args[1:])
+
+	if *remove {
+		_, _, err := bc.db.From("price_alerts").Delete("", "").Eq("asin", id).Execute()
+		if err != nil {
+			log.Fatal("ERR!: DATABASE:", err)
+		}
+		log.Printf("PRICE ALERT: removed %s\n", id)
+		return
+	}
+	if *below <= 0 && !*onSale {
+		log.Fatal("ERR!: PRICE ALERT: --below, --on-sale or both")
+	}
+	alert := map[string]interface{}{"asin": id, "below": *below, "on_sale": *onSale, "fired_at": nil, "fired_price": nil}
+	_, _, err := bc.db.From("price_alerts").Upsert(alert, "asin", "", "").Execute()
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("PRICE ALERT: %s below %.2f, on sale %t\n", id, *below, *onSale)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// a price_history table that sorts the way postgrest would
+func priceHistoryServer(t *testing.T, rows []priceSnapshot) *supabase.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order := r.URL.Query().Get("order")
+		if order != "asin.asc.nullslast,id.asc.nullslast" {
+			t.Errorf("order=%s, want ascending asin then id", order)
+		}
+		sorted := append([]priceSnapshot{}, rows...)
+		if strings.Contains(order, "desc") {
+			for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+				sorted[i], sorted[j] = sorted[j], sorted[i]
+			}
+		}
+		json.NewEncoder(w).Encode(sorted)
+	}))
+	t.Cleanup(server.Close)
+	db, err := supabase.NewClient(server.URL, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLoadLastPricesKeepsTheNewest(t *testing.T) {
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// in id order, oldest first
+	db := priceHistoryServer(t, []priceSnapshot{
+		{Id: "B0AAAAAAAA", Currency: "GBP", ListPrice: 19.99, SeenAt: seen},
+		{Id: "B0AAAAAAAA", Currency: "GBP", ListPrice: 19.99, SalePrice: 3.99, SeenAt: seen.AddDate(0, 1, 0)},
+		{Id: "B0AAAAAAAA", Currency: "GBP", ListPrice: 14.99, SeenAt: seen.AddDate(0, 2, 0)},
+		{Id: "B0BBBBBBBB", Currency: "GBP", ListPrice: 9.99, SeenAt: seen},
+	})
+	prices := loadLastPrices(db)
+	if p := prices["B0AAAAAAAA"]; p.ListPrice != 14.99 || p.SalePrice != 0 {
+		t.Errorf("B0AAAAAAAA = %+v, want the newest, 14.99 and no sale", p)
+	}
+	if p := prices["B0BBBBBBBB"]; p.ListPrice != 9.99 {
+		t.Errorf("B0BBBBBBBB = %+v, want 9.99", p)
+	}
+}
+
+func TestSamePrices(t *testing.T) {
+	a := priceSnapshot{Id: "B0AAAAAAAA", Currency: "GBP", ListPrice: 19.99, SalePrice: 3.99, SeenAt: time.Now()}
+	b := a
+	b.SeenAt = b.SeenAt.Add(time.Hour)
+	if !a.same(b) {
+		t.Error("the same prices seen at different times should be the same")
+	}
+	b.SalePrice = 0
+	if a.same(b) {
+		t.Error("a sale ending should be a change")
+	}
+	b = a
+	b.Currency = "EUR"
+	if a.same(b) {
+		t.Error("a different currency should be a change")
+	}
+}
@@ -0,0 +1,222 @@
+// priors.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Per-genre priors
+//
+// The Dirichlet ranker starts every book with one pseudo-vote per star, the
+// same for every book, but a 4.3 average means something different in Kids
+// Fantasy than it does in Hard SciFi.
+//
+// The priors command estimates a Dirichlet prior for each category from the
+// stored ratingsoverall histograms, using the method of moments over the share
+// of votes each star gets, and saves them in tag_priors under the category id.
+// It then re-scores every book against the prior of its category into
+// ratinggenre, falling back to a prior estimated from the whole catalogue. The
+// rating column is left alone, so the two can be compared.
+//
+// A book's category comes from its tags, as the category column is only set
+// for books added since it was: it's the most specific category whose tags it
+// has all of. Category tags overlap (Kids, Teen and adult Fantasy all have
+// "Fantasy"), so going by a single tag would lump different categories
+// together.
+
+// histograms with fewer ratings than this are mostly noise
+const priorMinRatings = 20
+
+// tags with fewer books than this don't get their own prior
+const priorMinBooks = 30
+
+// stored under this key, for books with no category, or a category with too few books
+const priorAllTag = "*"
+
+type tagPrior struct {
+	Tag       string    `json:"tag"`
+	Alpha     []float64 `json:"alpha"`
+	Books     int       `json:"books"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// method of moments: for each star the share of votes p has mean m and
+// variance m(1-m)/(A+1), where A is the prior's total pseudo-votes, so each
+// star gives an estimate of A, and we take the median
+func estimateDirichletPrior(histograms [][]int) []float64 {
+	K := len(stars)
+	shares := make([][]float64, K)
+	for _, ns := range histograms {
+		N := float64(sum(ns))
+		for k, n := range ns {
+			shares[k] = append(shares[k], float64(n)/N)
+		}
+	}
+	means := make([]float64, K)
+	estimates := []float64{}
+	for k := 0; k < K; k++ {
+		mean, variance := 0.0, 0.0
+		for _, p := range shares[k] {
+			mean += p
+		}
+		mean /= float64(len(shares[k]))
+		for _, p := range shares[k] {
+			variance += (p - mean) * (p - mean)
+		}
+		variance /= float64(len(shares[k]))
+		means[k] = mean
+		if variance > 0 && mean > 0 && mean < 1 {
+			estimates = append(estimates, mean*(1-mean)/variance-1)
+		}
+	}
+	// fall back to the uniform prior's strength
+	A := float64(K)
+	if len(estimates) > 0 {
+		sort.Float64s(estimates)
+		A = math.Max(estimates[len(estimates)/2], 1)
+	}
+	alpha := make([]float64, K)
+	for k := range alpha {
+		// never let a star have no pseudo-votes at all
+		alpha[k] = math.Max(means[k]*A, 0.01)
+	}
+	return alpha
+}
+
+// the most specific category whose tags are all in tags, or "" if there's
+// none. When two are as specific, the later in categories wins, as that lists
+// the sub-categories last.
+func categoryFromTags(tags map[string]bool) Category {
+	var best Category
+	bestTags := 0
+	for _, c := range categories {
+		ctags := c.Tags()
+		if len(ctags) < bestTags {
+			continue
+		}
+		all := true
+		for _, t := range ctags {
+			if !tags[t] {
+				all = false
+				break
+			}
+		}
+		if all {
+			best, bestTags = c, len(ctags)
+		}
+	}
+	return best
+}
+
+// every tagged book's category, by asin
+func loadBookCategories(db *supabase.Client) map[string]Category {
+	tags := map[string]map[string]bool{}
+	err := forEachRow(db, "tags", "asin,tag", func(t map[string]string) {
+		if tags[t["asin"]] == nil {
+			tags[t["asin"]] = map[string]bool{}
+		}
+		tags[t["asin"]][t["tag"]] = true
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	categories := map[string]Category{}
+	for id, ts := range tags {
+		if c := categoryFromTags(ts); c != "" {
+			categories[id] = c
+		}
+	}
+	return categories
+}
+
+// a tag_priors key for humans
+func priorName(key string) string {
+	if key == priorAllTag {
+		return "everything"
+	}
+	return Category(key).Friendly()
+}
+
+func (bc *BookCollector) estimatePriors() {
+	// every usable histogram
+	histograms := map[string][]int{}
+	books := []Book{}
+	err := forEachRow(bc.db, "books", "asin,title,ratingsoverall,hidden", func(b Book) {
+		if b.Hidden {
+			return
+		}
+		books = append(books, b)
+		ns, err := stringsToInts(b.RatingsOverall)
+		if err != nil || checkHistogram(ns) != nil || sum(ns) < priorMinRatings {
+			return
+		}
+		histograms[b.Id] = ns
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+
+	// group them by category
+	bookCategories := loadBookCategories(bc.db)
+	byCategory := map[string][][]int{}
+	for id, ns := range histograms {
+		if c, ok := bookCategories[id]; ok {
+			byCategory[string(c)] = append(byCategory[string(c)], ns)
+		}
+		byCategory[priorAllTag] = append(byCategory[priorAllTag], ns)
+	}
+
+	// estimate and store
+	priors := map[string][]float64{}
+	keys := []string{}
+	for key, hs := range byCategory {
+		if len(hs) < priorMinBooks {
+			continue
+		}
+		p := tagPrior{Tag: key, Alpha: estimateDirichletPrior(hs), Books: len(hs), UpdatedAt: time.Now().UTC()}
+		priors[key] = p.Alpha
+		keys = append(keys, fmt.Sprintf("%q", key))
+		log.Printf("- PRIOR: %s (%d books) %.3g", priorName(key), p.Books, p.Alpha)
+		_, _, err := bc.db.From("tag_priors").Upsert(p, "tag", "", "").Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: tag_priors: %s %s", key, err)
+		}
+	}
+	// and forget any we no longer have, e.g. the old per-tag priors
+	if len(keys) > 0 {
+		_, _, err = bc.db.From("tag_priors").Delete("", "").Not("tag", "in", "("+strings.Join(keys, ",")+")").Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: tag_priors: %s", err)
+		}
+	}
+	log.Printf("PRIORS: %d categories with enough books\n", len(priors))
+	if priors[priorAllTag] == nil {
+		log.Println("PRIORS: not enough books for a catalogue prior, not re-scoring")
+		return
+	}
+
+	// re-score every book against the prior of its category
+	z := envFloat("RANKER_Z", 1.65)
+	scores := []map[string]interface{}{}
+	for _, b := range books {
+		if len(b.RatingsOverall) == 0 {
+			continue
+		}
+		ranker, tag := genreRanker(priors, z, bookCategories[b.Id])
+		scores = append(scores, map[string]interface{}{
+			"asin":             b.Id,
+			"ratinggenre":      bc.rankWith(ranker, b.Id, b.RatingsOverall),
+			"ratinggenreprior": tag,
+		})
+	}
+	bc.updateBookScores(scores)
+	log.Printf("PRIORS: re-scored %d books\n", len(scores))
+}
@@ -0,0 +1,196 @@
+// reapplybans.go
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+	"github.com/supabase/postgrest-go"
+)
+
+// Reapplying bans
+//
+// Ban rules only stop books coming in, so anything already in books stays
+// searchable after a new rule is added. The reapply-bans command runs the
+// current rules over every stored book and reports what matches:
+//
+// 	laud reapply-bans --dry-run      # just report
+// 	laud reapply-bans                # hide the matches
+// 	laud reapply-bans --delete       # delete them instead
+// 	laud reapply-bans --undo <batch> # put a run back the way it was
+//
+// Hidden books keep their row, so the crawler won't fetch them again, but lose
+// their tags rows so they drop out of tag searches, and the crawler doesn't
+// tag them again when they turn up on a list. Every change is written to
+// ban_undo_log first, with a copy of the book and its tags, under a batch id
+// so a whole run can be undone.
+
+// the hidden books, by asin
+func loadHidden(db *supabase.Client) map[string]bool {
+	hidden := map[string]bool{}
+	onlyHidden := func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder { return q.Eq("hidden", "true") }
+	err := forEachPage(db, "books", "asin", onlyHidden, func(books []Book) error {
+		for _, b := range books {
+			hidden[b.Id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return hidden
+}
+
+const (
+	banActionHide   = "hide"
+	banActionDelete = "delete"
+)
+
+type banUndo struct {
+	Id     int             `json:"id,omitempty"`
+	Batch  string          `json:"batch"`
+	Asin   string          `json:"asin"`
+	Action string          `json:"action"`
+	Rule   string          `json:"rule"`
+	Book   json.RawMessage `json:"book"`
+	Tags   []string        `json:"tags"`
+}
+
+type banMatch struct {
+	book    Book
+	rule    *BanRule
+	matched string
+}
+
+func (bc *BookCollector) reapplyBans(args []string) {
+	flags := flag.NewFlagSet("reapply-bans", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report matches without changing anything")
+	deleteBooks := flags.Bool("delete", false, "delete matched books rather than hiding them")
+	undo := flags.String("undo", "", "undo the batch with this id")
+	flags.Parse(args)
+
+	if *undo != "" {
+		bc.undoBans(*undo)
+		return
+	}
+
+	// evaluate the current rules against every stored book
+	matches := []banMatch{}
+	err := forEachRow(bc.db, "books", "asin,title,subtitle,author,series,summary,tags,hidden", func(b Book) {
+		if b.Hidden && !*deleteBooks {
+			return
+		}
+		if rule, matched := bc.banRules.match(bookBanFields(&b)); rule != nil {
+			matches = append(matches, banMatch{book: b, rule: rule, matched: matched})
+		}
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+
+	for _, m := range matches {
+		log.Printf("- BAN: %s %s, by %s: %s '%s'", m.book.Id, m.book.Title, m.book.Author, m.rule.Field, m.rule.describe(m.matched))
+	}
+	log.Printf("BANS: %d stored books match the current ban rules\n", len(matches))
+	if *dryRun || len(matches) == 0 {
+		return
+	}
+
+	action := banActionHide
+	if *deleteBooks {
+		action = banActionDelete
+	}
+	batch := time.Now().UTC().Format("20060102-150405")
+	for _, m := range matches {
+		bc.applyBan(batch, action, m)
+	}
+	log.Printf("BANS: %s %d books, undo with: laud reapply-bans --undo %s\n", action, len(matches), batch)
+}
+
+func (bc *BookCollector) applyBan(batch, action string, m banMatch) {
+	id := m.book.Id
+
+	// keep enough to put it all back
+	rows := []json.RawMessage{}
+	_, err := bc.db.From("books").Select("*", "", false).Eq("asin", id).ExecuteTo(&rows)
+	if err != nil || len(rows) == 0 {
+		log.Printf("ERR!: DATABASE: books: id:%s %v", id, err)
+		return
+	}
+	tags, err := loadBookTags(bc.db, id)
+	if err != nil {
+		log.Printf("ERR!: DATABASE: tags: id:%s %s", id, err)
+		return
+	}
+	u := banUndo{
+		Batch:  batch,
+		Asin:   id,
+		Action: action,
+		Rule:   m.rule.describe(m.matched),
+		Book:   rows[0],
+		Tags:   tags,
+	}
+	_, _, err = bc.db.From("ban_undo_log").Insert(u, false, "", "", "").Execute()
+	if err != nil {
+		// no undo, no change
+		log.Printf("ERR!: DATABASE: ban_undo_log: id:%s %s", id, err)
+		return
+	}
+
+	if action == banActionDelete {
+		_, _, err = bc.db.From("books").Delete("", "").Eq("asin", id).Execute()
+	} else {
+		_, _, err = bc.db.From("books").Update(map[string]interface{}{"hidden": true}, "", "").Eq("asin", id).Execute()
+	}
+	if err != nil {
+		log.Printf("ERR!: DATABASE: books: id:%s %s", id, err)
+		return
+	}
+	_, _, err = bc.db.From("tags").Delete("", "").Eq("asin", id).Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: tags: id:%s %s", id, err)
+	}
+	log.Printf("- • %s: %s %s", action, id, m.book.Title)
+}
+
+func (bc *BookCollector) undoBans(batch string) {
+	undos := []banUndo{}
+	count, err := bc.db.From("ban_undo_log").
+		Select("id,batch,asin,action,rule,book,tags", "exact", false).
+		Eq("batch", batch).
+		Is("undone_at", "null").
+		ExecuteTo(&undos)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("BANS: undoing %d changes from batch %s\n", count, batch)
+
+	for _, u := range undos {
+		if u.Action == banActionDelete {
+			_, _, err = bc.db.From("books").Insert(u.Book, false, "", "", "").Execute()
+		} else {
+			_, _, err = bc.db.From("books").Update(map[string]interface{}{"hidden": false}, "", "").Eq("asin", u.Asin).Execute()
+		}
+		if err != nil {
+			log.Printf("ERR!: DATABASE: books: id:%s %s", u.Asin, err)
+			continue
+		}
+		for _, tag := range u.Tags {
+			bc.addBookTagToDB(u.Asin, tag)
+		}
+		bc.books[u.Asin] = true
+		_, _, err = bc.db.From("ban_undo_log").
+			Update(map[string]interface{}{"undone_at": time.Now().UTC()}, "", "").
+			Eq("id", strconv.Itoa(u.Id)).
+			Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: ban_undo_log: id:%s %s", u.Asin, err)
+		}
+		log.Printf("- • UNDO %s: %s", u.Action, u.Asin)
+	}
+}
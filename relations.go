@@ -0,0 +1,273 @@
+// relations.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+)
+
+// Book relations
+//
+// Product pages have carousels of other books: 'Listeners also enjoyed',
+// 'More from the same author', 'More from the same series'. Each book in them
+// is stored in book_relations, from the book whose page it was on (asin) to
+// the book in the carousel (related_asin), with the kind of relation and its
+// position in the carousel, 1 first.
+//
+// They're used three ways:
+//
+// 	laud recommend            books related to the ones we liked in our library
+// 	laud crawl --discover 2   also fetch related books, and theirs, that we haven't seen
+// 	book_relation_counts      a view of how often each book is recommended, for popularity
+//
+// Discovered books go through the product page filters, but have no category
+// or popularity, as they weren't on a list.
+
+const (
+	relationAlsoEnjoyed = "also-enjoyed"
+	relationSameAuthor  = "same-author"
+	relationSameSeries  = "same-series"
+	relationRelated     = "related"
+)
+
+// how much each kind of relation counts when recommending
+var relationWeights = map[string]float64{
+	relationAlsoEnjoyed: 1,
+	relationSameAuthor:  0.5,
+	relationSameSeries:  0.75,
+	relationRelated:     0.5,
+}
+
+var relationKindRxs = []struct {
+	kind string
+	rx   *regexp.Regexp
+}{
+	{relationAlsoEnjoyed, regexp.MustCompile(`(?i)also\s+(enjoyed|listened|bought|liked)`)},
+	{relationSameSeries, regexp.MustCompile(`(?i)series`)},
+	{relationSameAuthor, regexp.MustCompile(`(?i)(more|listens)\s+(from|by)`)},
+}
+
+var findPdAsinRx = regexp.MustCompile(`/pd/(?:[^/?#]+/)?([0-9A-Z]{10})\b`)
+
+type bookRelation struct {
+	Id        string    `json:"asin"`
+	RelatedId string    `json:"related_asin"`
+	Relation  string    `json:"relation"`
+	Position  int       `json:"position"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+func relationKind(heading string) string {
+	for _, k := range relationKindRxs {
+		if k.rx.MatchString(heading) {
+			return k.kind
+		}
+	}
+	return relationRelated
+}
+
+// the carousel's heading, which is above it rather than in it
+func carouselHeading(carousel *goquery.Selection) string {
+	for s := carousel; s.Length() > 0; s = s.Parent() {
+		if h := strings.TrimSpace(s.Find("h2").First().Text()); h != "" {
+			return h
+		}
+		if s.Is("body") {
+			break
+		}
+	}
+	return ""
+}
+
+func findRelations(id string, e *colly.HTMLElement) []bookRelation {
+	relations := []bookRelation{}
+	seen := map[string]bool{id: true}
+	now := time.Now().UTC()
+	e.DOM.Find(".bc-carousel, adbl-product-carousel").Each(func(_ int, carousel *goquery.Selection) {
+		kind := relationKind(carouselHeading(carousel))
+		position := 0
+		carousel.Find(`a[href*="/pd/"]`).Each(func(_ int, a *goquery.Selection) {
+			href, _ := a.Attr("href")
+			m := findPdAsinRx.FindStringSubmatch(href)
+			if len(m) == 0 || seen[m[1]] {
+				return
+			}
+			seen[m[1]] = true
+			position++
+			relations = append(relations, bookRelation{Id: id, RelatedId: m[1], Relation: kind, Position: position, SeenAt: now})
+		})
+	})
+	return relations
+}
+
+func (bc *BookCollector) addRelationsToDB(id string, relations []bookRelation) {
+	if id == "" || len(relations) == 0 {
+		return
+	}
+	_, _, err := bc.db.From("book_relations").Upsert(relations, "asin,related_asin,relation", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: book_relations: id:%s %s", id, err)
+	}
+}
+
+// fetch the related books we've not seen, one step further out
+func (bc *BookCollector) discover(relations []bookRelation, depth int) {
+	if depth >= bc.discoverDepth {
+		return
+	}
+	for _, r := range relations {
+		if bc.books[r.RelatedId] {
+			continue
+		}
+		log.Printf("- - DISCOVER: %s (depth %d) from %s\n", r.RelatedId, depth+1, r.Id)
+		ctx := colly.NewContext()
+		ctx.Put("depth", strconv.Itoa(depth+1))
+		bc.detailCollector.Request("GET", baseBookUrl+r.RelatedId, nil, ctx, nil)
+	}
+}
+
+// how much we liked a book in our library, from -1 to 1
+func tasteWeight(l libraryBook) float64 {
+	switch {
+	case l.PersonalRating > 0:
+		return (l.PersonalRating - 3) / 2
+	case l.Finished:
+		return 0.5
+	case l.Owned:
+		return 0.25
+	}
+	return 0
+}
+
+type recommendation struct {
+	book  Book
+	score float64
+	// how much each library book added, by title
+	reasons map[string]float64
+}
+
+func (bc *BookCollector) recommend(args []string) {
+	flags := flag.NewFlagSet("recommend", flag.ExitOnError)
+	top := flags.Int("top", 20, "how many to recommend")
+	filter := bookFilter{}
+	filter.addFlags(flags)
+	// addFlags sets the defaults, and we don't want what we already own,
+	// unless --hide-owned=false
+	filter.hideOwned = true
+	flags.Parse(args)
+
+	library := bc.loadLibrary()
+	filter.owned = ownedBooks(library)
+	seeds := []string{}
+	for id, l := range library {
+		if tasteWeight(l) != 0 {
+			seeds = append(seeds, id)
+		}
+	}
+	if len(seeds) == 0 {
+		log.Fatal("ERR!: RECOMMEND: nothing in the library to go on, see import-library")
+	}
+	sort.Strings(seeds)
+
+	// everything related to something in the library
+	scores := map[string]*recommendation{}
+	for from := 0; from < len(seeds); from += dbLookupSize {
+		to := from + dbLookupSize
+		if to > len(seeds) {
+			to = len(seeds)
+		}
+		rows := []bookRelation{}
+		_, err := bc.db.From("book_relations").Select("asin,related_asin,relation,position", "", false).In("asin", seeds[from:to]).ExecuteTo(&rows)
+		if err != nil {
+			log.Fatal("ERR!: DATABASE:", err)
+		}
+		for _, r := range rows {
+			seed := library[r.Id]
+			score := tasteWeight(seed) * relationWeights[r.Relation] / float64(r.Position)
+			rec, ok := scores[r.RelatedId]
+			if !ok {
+				rec = &recommendation{reasons: map[string]float64{}}
+				scores[r.RelatedId] = rec
+			}
+			rec.score += score
+			if score > 0 {
+				name := seed.Title
+				if name == "" {
+					name = seed.Id
+				}
+				rec.reasons[name] += score
+			}
+		}
+	}
+
+	// which of those pass the filters
+	ids := []string{}
+	for id, rec := range scores {
+		if rec.score > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	recs := []*recommendation{}
+	for from := 0; from < len(ids); from += dbLookupSize {
+		to := from + dbLookupSize
+		if to > len(ids) {
+			to = len(ids)
+		}
+		chunk := ids[from:to]
+		books := []Book{}
+		_, err := filter.where(bc.db.From("books").Select("asin,title,author,rating,popularity", "", false)).In("asin", chunk).ExecuteTo(&books)
+		if err != nil {
+			log.Fatal("ERR!: DATABASE:", err)
+		}
+		tags, err := loadTagsFor(bc.db, chunk)
+		if err != nil {
+			log.Fatal("ERR!: DATABASE:", err)
+		}
+		for _, b := range books {
+			if !filter.matchLocal(b.Id, tags[b.Id]) {
+				continue
+			}
+			rec := scores[b.Id]
+			rec.book = b
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].score != recs[j].score {
+			return recs[i].score > recs[j].score
+		}
+		return recs[i].book.Rating > recs[j].book.Rating
+	})
+	if len(recs) > *top {
+		recs = recs[:*top]
+	}
+	for _, rec := range recs {
+		log.Printf("- RECOMMEND: %s %.2f (%1.2f★) %s, by %s\n", rec.book.Id, rec.score, rec.book.Rating, rec.book.Title, rec.book.Author)
+		log.Printf("- - because: %s\n", strongestReasons(rec.reasons, 3))
+	}
+	log.Printf("RECOMMEND: %d of %d related books, from %d in the library\n", len(recs), len(ids), len(seeds))
+}
+
+// the library books that did most for a recommendation
+func strongestReasons(reasons map[string]float64, n int) string {
+	titles := []string{}
+	for title := range reasons {
+		titles = append(titles, title)
+	}
+	sort.Slice(titles, func(i, j int) bool { return reasons[titles[i]] > reasons[titles[j]] })
+	if len(titles) > n {
+		titles = append(titles[:n], fmt.Sprintf("and %d more", len(reasons)-n))
+	}
+	return strings.Join(titles, ", ")
+}
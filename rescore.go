@@ -0,0 +1,124 @@
+// rescore.go
+
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Rescoring
+//
+// The rating columns are only worked out at scrape time, but the histograms
+// they come from are stored too, so the rescore command re-derives every
+// rating column from them with the current ranker (and the per-genre priors,
+// if the priors command has been run), along with the laud score. Changing
+// the algorithm no longer means a multi-hour re-crawl.
+//
+// Scores are sent in batches to update_book_scores, which updates each batch
+// in a single transaction, so a failure part way through leaves every book
+// with a consistent set of scores. It returns how many books it updated.
+
+// books per update_book_scores call
+const scoreBatchSize = 500
+
+// each score is a map from column to value, keyed by "asin"
+// columns that aren't in the map are left alone
+func (bc *BookCollector) updateBookScores(scores []map[string]interface{}) {
+	for from := 0; from < len(scores); from += scoreBatchSize {
+		to := from + scoreBatchSize
+		if to > len(scores) {
+			to = len(scores)
+		}
+		// update_book_scores RPC
+		// anything but a count is an error, and if the request itself fails
+		// supabase-go returns "" (the error it keeps isn't exported)
+		result := bc.db.Rpc("update_book_scores", "", map[string]interface{}{"scores": scores[from:to]})
+		updated, err := strconv.Atoi(result)
+		if err != nil {
+			if result == "" {
+				result = "no response"
+			}
+			log.Printf("ERR!: DATABASE: update_book_scores: books %d to %d: %s", from+1, to, result)
+			continue
+		}
+		log.Printf("- SCORES: updated books %d to %d of %d (%d found)", from+1, to, len(scores), updated)
+	}
+}
+
+// the stored priors, by category id
+func (bc *BookCollector) loadPriors() map[string][]float64 {
+	rows := []tagPrior{}
+	_, err := bc.db.From("tag_priors").Select("tag,alpha", "", false).ExecuteTo(&rows)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	priors := map[string][]float64{}
+	for _, p := range rows {
+		priors[p.Tag] = p.Alpha
+	}
+	return priors
+}
+
+// the ranker for a book's genre score, and the prior it uses, or nil if there
+// are no priors
+func genreRanker(priors map[string][]float64, z float64, c Category) (Ranker, string) {
+	key := string(c)
+	if priors[key] == nil {
+		key = priorAllTag
+	}
+	if priors[key] == nil {
+		return nil, ""
+	}
+	return DirichletRanker{Z: z, Prior: priors[key]}, key
+}
+
+func (bc *BookCollector) rescore(args []string) {
+	flags := flag.NewFlagSet("rescore", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "work out the scores without saving them")
+	flags.Parse(args)
+
+	priors := bc.loadPriors()
+	z := envFloat("RANKER_Z", 1.65)
+	log.Printf("RESCORE: with %s and %d genre priors\n", bc.ranker.Name(), len(priors))
+
+	scores := []map[string]interface{}{}
+	now := time.Now()
+	bookCategories := loadBookCategories(bc.db)
+	columns := "asin,title,releasedate,popularity,ratingsoverall,ratingsperformance,ratingsstory"
+	err := forEachRow(bc.db, "books", columns, func(b Book) {
+		score := map[string]interface{}{"asin": b.Id, "ranker": bc.ranker.Name()}
+		if len(b.RatingsOverall) > 0 {
+			b.Rating = bc.rank(b.Id, b.RatingsOverall)
+			score["rating"] = b.Rating
+			if ranker, tag := genreRanker(priors, z, bookCategories[b.Id]); ranker != nil {
+				score["ratinggenre"] = bc.rankWith(ranker, b.Id, b.RatingsOverall)
+				score["ratinggenreprior"] = tag
+			}
+		}
+		if len(b.RatingsPerformance) > 0 {
+			b.RatingPerformance = bc.rank(b.Id, b.RatingsPerformance)
+			score["ratingperformance"] = b.RatingPerformance
+		}
+		if len(b.RatingsStory) > 0 {
+			b.RatingStory = bc.rank(b.Id, b.RatingsStory)
+			score["ratingstory"] = b.RatingStory
+		}
+		// the laud score depends on all of the above, and popularity
+		score["laudscore"], score["laudscoreexplain"] = laudScore(&b, now)
+		if *dryRun {
+			log.Printf("- SCORE: %s %v (laud %.1f) %s", b.Id, score["rating"], score["laudscore"], b.Title)
+		}
+		scores = append(scores, score)
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("RESCORE: %d books\n", len(scores))
+	if *dryRun {
+		return
+	}
+	bc.updateBookScores(scores)
+}
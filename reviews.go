@@ -0,0 +1,284 @@
+// reviews.go
+
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+	"github.com/supabase-community/supabase-go"
+)
+
+// Reviews
+//
+// The histograms say how a book is rated, but not why. The top
+// REVIEWS_PER_BOOK (default 10) reviews on each product page are kept in
+// book_reviews, in the order Audible shows them: title, body, overall,
+// performance and story stars, the date, and how many people found it
+// helpful. They're replaced every time we read the page.
+//
+// Each book also gets a row in review_signals, derived from them:
+//
+// 	reviews      how many reviews the page says it has
+// 	avg_words    the average length of the ones we kept, in words
+// 	keywords     the words most often used in them, e.g. {narrator, twist, worldbuilding}
+// 	generic      the share that are short 5★ reviews, or near copies of another
+//
+// The site shows them on each book's page, and suspects uses generic as a
+// signal, as bought reviews tend to be short, glowing and alike.
+
+// 'Overall 5 out of 5 stars', in the text for screen readers
+var findReviewStarsRx = regexp.MustCompile(`(?i)(Overall|Performance|Story)\s+(\d(?:\.\d)?)\s+out\s+of\s+5\s+stars`)
+var findHelpfulRx = regexp.MustCompile(`(?i)([\d,]+)\s+(?:people|person)\s+found\s+this\s+helpful`)
+
+// the text runs together, '5 out of 5 stars12-03-2023', so no \b
+var findReviewDateRx = regexp.MustCompile(`(\d{2}-\d{2}-\d{4})`)
+var findReviewCountRx = regexp.MustCompile(`(?i)([\d,]+)\s+reviews?`)
+var reviewWordsRx = regexp.MustCompile(`\p{L}+(?:'\p{L}+)?`)
+
+// the ratings and reviews section, as for the histograms
+const reviewsSelector = "#center-16"
+
+// keywords in at least this many reviews, and this many of them
+const (
+	keywordMinReviews = 2
+	keywordsPerBook   = 8
+)
+
+// reviews this short, at 5★, say nothing
+const genericMaxWords = 15
+
+// words that say nothing about a book
+var reviewStopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		about after again all also and any are because been before being book books but can could
+		did does doing don't down each even ever every for from get got had has have her here his
+		how i'm into its it's just like listen listened listening made make many more most much
+		narration not now off one only other our out over own read really same she should some
+		story such than that the their them then there these they this those through too under
+		until very was way well were what when where which while who why will with would you your`) {
+		reviewStopWords[w] = true
+	}
+}
+
+type bookReview struct {
+	Id          string    `json:"asin"`
+	Position    int       `json:"position"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body"`
+	Overall     float64   `json:"overall"`
+	Performance float64   `json:"performance"`
+	Story       float64   `json:"story"`
+	ReviewedOn  Date      `json:"reviewed_on"`
+	Helpful     int       `json:"helpful"`
+	SeenAt      time.Time `json:"seen_at"`
+}
+
+type reviewSignals struct {
+	Id        string    `json:"asin"`
+	Reviews   int       `json:"reviews"`
+	AvgWords  float64   `json:"avg_words"`
+	Keywords  []string  `json:"keywords"`
+	Generic   float64   `json:"generic"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func reviewWords(text string) []string {
+	return reviewWordsRx.FindAllString(strings.ToLower(text), -1)
+}
+
+// the innermost block with one title and its stars, the markup changes too
+// often to rely on anything more specific
+func isReview(s *goquery.Selection) bool {
+	return s.Find("h3").Length() == 1 && findReviewStarsRx.MatchString(s.Text())
+}
+
+func parseReview(s *goquery.Selection) bookReview {
+	r := bookReview{Title: strings.TrimSpace(s.Find("h3").Text())}
+	// the body is the longest paragraph, the rest are the reviewer and votes
+	s.Find("p").Each(func(_ int, p *goquery.Selection) {
+		if text := strings.TrimSpace(p.Text()); len(text) > len(r.Body) {
+			r.Body = text
+		}
+	})
+	text := s.Text()
+	for _, m := range findReviewStarsRx.FindAllStringSubmatch(text, -1) {
+		stars, _ := strconv.ParseFloat(m[2], 64)
+		switch strings.ToLower(m[1]) {
+		case "overall":
+			r.Overall = stars
+		case "performance":
+			r.Performance = stars
+		case "story":
+			r.Story = stars
+		}
+	}
+	if m := findHelpfulRx.FindStringSubmatch(text); len(m) > 0 {
+		r.Helpful, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	}
+	if m := findReviewDateRx.FindStringSubmatch(text); len(m) > 0 {
+		if t, err := time.Parse("02-01-2006", m[1]); err == nil {
+			r.ReviewedOn = Date{t}
+		}
+	}
+	return r
+}
+
+// the top reviews on a product page, and how many it says there are
+func findReviews(id string, e *colly.HTMLElement) ([]bookReview, int) {
+	keep := int(envFloat("REVIEWS_PER_BOOK", 10))
+	section := e.DOM.Find(reviewsSelector)
+	blocks := section.Find("div").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return isReview(s) && s.Find("div").FilterFunction(func(_ int, c *goquery.Selection) bool { return isReview(c) }).Length() == 0
+	})
+	reviews := []bookReview{}
+	now := time.Now().UTC()
+	blocks.EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if len(reviews) >= keep {
+			return false
+		}
+		r := parseReview(s)
+		if r.Body == "" {
+			return true
+		}
+		r.Id = id
+		r.Position = len(reviews) + 1
+		r.SeenAt = now
+		reviews = append(reviews, r)
+		return true
+	})
+	total := len(reviews)
+	if m := findReviewCountRx.FindStringSubmatch(section.Text()); len(m) > 0 {
+		if n, err := strconv.Atoi(strings.ReplaceAll(m[1], ",", "")); err == nil && n > total {
+			total = n
+		}
+	}
+	return reviews, total
+}
+
+// the share of reviews that are short and glowing, or near copies of another
+func genericShare(reviews []bookReview) float64 {
+	if len(reviews) < 3 {
+		return 0
+	}
+	words := make([]map[string]bool, len(reviews))
+	for i, r := range reviews {
+		words[i] = titleWords(r.Body)
+	}
+	generic := 0
+	for i, r := range reviews {
+		if r.Overall == 5 && len(reviewWords(r.Body)) < genericMaxWords {
+			generic++
+			continue
+		}
+		for j := range reviews {
+			if i != j && jaccard(words[i], words[j]) >= 0.8 {
+				generic++
+				break
+			}
+		}
+	}
+	return float64(generic) / float64(len(reviews))
+}
+
+// the words used in most reviews, counting each once a review
+func reviewKeywords(reviews []bookReview) []string {
+	counts := map[string]int{}
+	for _, r := range reviews {
+		seen := map[string]bool{}
+		for _, w := range reviewWords(r.Title + " " + r.Body) {
+			if len(w) < 4 || reviewStopWords[w] || seen[w] {
+				continue
+			}
+			seen[w] = true
+			counts[w]++
+		}
+	}
+	keywords := []string{}
+	for w, n := range counts {
+		if n >= keywordMinReviews {
+			keywords = append(keywords, w)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	if len(keywords) > keywordsPerBook {
+		keywords = keywords[:keywordsPerBook]
+	}
+	return keywords
+}
+
+func summariseReviews(id string, total int, reviews []bookReview) reviewSignals {
+	s := reviewSignals{Id: id, Reviews: total, Keywords: reviewKeywords(reviews), Generic: genericShare(reviews), UpdatedAt: time.Now().UTC()}
+	if len(reviews) > 0 {
+		words := 0
+		for _, r := range reviews {
+			words += len(reviewWords(r.Body))
+		}
+		s.AvgWords = float64(words) / float64(len(reviews))
+	}
+	return s
+}
+
+// replaces the book's reviews with the ones on the page
+func (bc *BookCollector) addReviewsToDB(id string, reviews []bookReview, total int) {
+	if id == "" {
+		return
+	}
+	_, _, err := bc.db.From("book_reviews").Delete("", "").Eq("asin", id).Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: book_reviews: id:%s %s", id, err)
+		return
+	}
+	if len(reviews) > 0 {
+		_, _, err = bc.db.From("book_reviews").Insert(reviews, false, "", "", "").Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: book_reviews: id:%s %s", id, err)
+		}
+	}
+	_, _, err = bc.db.From("review_signals").Upsert(summariseReviews(id, total, reviews), "asin", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: review_signals: id:%s %s", id, err)
+	}
+}
+
+// the first few reviews, and the signals, for a page of books, by asin
+func loadReviewsFor(db *supabase.Client, ids []string, top int) (map[string][]bookReview, map[string]*reviewSignals, error) {
+	reviews := map[string][]bookReview{}
+	signals := map[string]*reviewSignals{}
+	for from := 0; from < len(ids); from += dbLookupSize {
+		to := from + dbLookupSize
+		if to > len(ids) {
+			to = len(ids)
+		}
+		rows := []bookReview{}
+		_, err := db.From("book_reviews").Select("*", "", false).In("asin", ids[from:to]).Lte("position", strconv.Itoa(top)).Order("position", ascending).ExecuteTo(&rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range rows {
+			reviews[r.Id] = append(reviews[r.Id], r)
+		}
+		ss := []reviewSignals{}
+		_, err = db.From("review_signals").Select("*", "", false).In("asin", ids[from:to]).ExecuteTo(&ss)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range ss {
+			signals[ss[i].Id] = &ss[i]
+		}
+	}
+	return reviews, signals, nil
+}
@@ -0,0 +1,96 @@
+// revisits.go
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Revisit queue
+//
+// New releases often go a week or two before anyone rates them, and books
+// marked 'Not rated yet' are skipped, so they'd never turn up unless they
+// happened to rank in a later crawl.
+//
+// Instead, they're queued in the revisits table and the revisit command fetches
+// their product pages directly once they're due. If they still don't have a
+// ratings histogram we back off and try again later, giving up after the last
+// step in the schedule. If a book we gave up on turns up unrated again it's
+// queued again from the start.
+
+// days to wait before each visit
+var revisitSchedule = []int{7, 14, 30}
+
+type revisit struct {
+	Id       string   `json:"asin"`
+	Title    string   `json:"title"`
+	Category Category `json:"category"`
+	Attempts int      `json:"attempts"`
+}
+
+func nextVisit(attempts int) time.Time {
+	return time.Now().UTC().AddDate(0, 0, revisitSchedule[attempts])
+}
+
+func (bc *BookCollector) addRevisitToDB(id, title string) {
+	if id == "" {
+		return
+	}
+	// queue_revisit RPC
+	// does nothing if the book is already queued, so we don't reset its
+	// back-off, but starts again if we'd finished with it
+	bc.db.Rpc("queue_revisit", "", map[string]interface{}{
+		"asin":       id,
+		"title":      title,
+		"category":   bc.currentCategory,
+		"next_visit": nextVisit(0),
+	})
+}
+
+func (bc *BookCollector) revisitUnrated() {
+	now := time.Now().UTC().Format(time.RFC3339)
+	due := []revisit{}
+	count, err := bc.db.From("revisits").
+		Select("asin,title,category,attempts", "exact", false).
+		Is("done_at", "null").
+		Lte("next_visit", now).
+		ExecuteTo(&due)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("REVISIT: %d unrated books due\n", count)
+
+	for _, r := range due {
+		bc.currentCategory = r.Category
+		update := map[string]interface{}{"last_visit": time.Now().UTC()}
+		if bc.visitBook(r.Id) {
+			log.Println("- • RATED:", r.Id, r.Title)
+			update["done_at"] = time.Now().UTC()
+			// tag it with the category we first saw it in, as the list
+			// collector would have, now it's in books
+			for _, tag := range r.Category.Tags() {
+				bc.addBookTagToDB(r.Id, tag)
+			}
+		} else {
+			r.Attempts++
+			update["attempts"] = r.Attempts
+			if r.Attempts < len(revisitSchedule) {
+				update["next_visit"] = nextVisit(r.Attempts)
+				log.Printf("- - SKIP: STILL NOT RATED: %s (next visit in %d days)", r.Title, revisitSchedule[r.Attempts])
+			} else {
+				// give up, it's had its chance
+				update["next_visit"] = nil
+				update["done_at"] = time.Now().UTC()
+				log.Println("- - SKIP: GIVING UP ON:", r.Title)
+			}
+		}
+		_, _, err := bc.db.From("revisits").Update(update, "", "").Eq("asin", r.Id).Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: revisits: id:%s %s", r.Id, err)
+		}
+	}
+
+	// tell the database to update all the tags
+	log.Println("TAGS: update:", bc.db.Rpc("update_all_tags", "", nil))
+}
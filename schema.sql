@@ -63,6 +63,39 @@ $$;
 
 ALTER FUNCTION "public"."insert_tag"("tag" "text", "asin" "text") OWNER TO "postgres";
 
+CREATE OR REPLACE FUNCTION "public"."queue_revisit"("asin" "text", "title" "text", "category" "text", "next_visit" timestamp with time zone) RETURNS "void"
+	LANGUAGE "plpgsql"
+	AS $$
+BEGIN
+  INSERT INTO public.revisits (asin, title, category, next_visit)
+  VALUES (asin, title, category, next_visit)
+  ON CONFLICT ON CONSTRAINT revisits_asin_key DO UPDATE
+  SET
+	title = EXCLUDED.title,
+	category = EXCLUDED.category,
+	attempts = 0,
+	next_visit = EXCLUDED.next_visit,
+	done_at = NULL
+  WHERE
+	public.revisits.done_at IS NOT NULL;
+END;
+$$;
+
+ALTER FUNCTION "public"."queue_revisit"("asin" "text", "title" "text", "category" "text", "next_visit" timestamp with time zone) OWNER TO "postgres";
+
+CREATE OR REPLACE FUNCTION "public"."sync_suspect_status"() RETURNS "trigger"
+	LANGUAGE "plpgsql"
+	AS $$
+BEGIN
+  UPDATE public.books
+  SET suspect = (NEW.status <> 'cleared')
+  WHERE asin = NEW.asin;
+  RETURN NEW;
+END;
+$$;
+
+ALTER FUNCTION "public"."sync_suspect_status"() OWNER TO "postgres";
+
 CREATE OR REPLACE FUNCTION "public"."update_all_tags"() RETURNS "void"
 	LANGUAGE "plpgsql"
 	AS $$
@@ -76,16 +109,91 @@ BEGIN
 	public.books
   where
 	tags is not null
+	and not hidden
   on conflict (tag, asin) do nothing;
 END;
 $$;
 
 ALTER FUNCTION "public"."update_all_tags"() OWNER TO "postgres";
 
+CREATE OR REPLACE FUNCTION "public"."update_book_scores"("scores" "jsonb") RETURNS integer
+	LANGUAGE "plpgsql"
+	AS $$
+DECLARE
+  updated integer;
+BEGIN
+  UPDATE public.books AS b
+  SET
+	rating = COALESCE((s->>'rating')::real, b.rating),
+	ratingperformance = COALESCE((s->>'ratingperformance')::real, b.ratingperformance),
+	ratingstory = COALESCE((s->>'ratingstory')::real, b.ratingstory),
+	ranker = COALESCE(s->>'ranker', b.ranker),
+	ratinggenre = COALESCE((s->>'ratinggenre')::real, b.ratinggenre),
+	ratinggenreprior = COALESCE(s->>'ratinggenreprior', b.ratinggenreprior),
+	laudscore = COALESCE((s->>'laudscore')::real, b.laudscore),
+	laudscoreexplain = COALESCE(s->'laudscoreexplain', b.laudscoreexplain),
+	updated_at = "timezone"('utc'::"text", "now"())
+  FROM
+	jsonb_array_elements(scores) AS s
+  WHERE
+	b.asin = s->>'asin';
+  GET DIAGNOSTICS updated = ROW_COUNT;
+  RETURN updated;
+END;
+$$;
+
+ALTER FUNCTION "public"."update_book_scores"("scores" "jsonb") OWNER TO "postgres";
+
 SET default_tablespace = '';
 
 SET default_table_access_method = "heap";
 
+CREATE TABLE IF NOT EXISTS "public"."ban_rules" (
+	"id" bigint NOT NULL,
+	"kind" "text" DEFAULT 'substring'::"text" NOT NULL,
+	"field" "text" DEFAULT 'text'::"text" NOT NULL,
+	"pattern" "text" NOT NULL,
+	"case_sensitive" boolean DEFAULT false NOT NULL,
+	"exceptions" "text"[],
+	"reason" "text",
+	"enabled" boolean DEFAULT true NOT NULL,
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."ban_rules" OWNER TO "postgres";
+
+ALTER TABLE "public"."ban_rules" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."ban_rules_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."ban_undo_log" (
+	"id" bigint NOT NULL,
+	"batch" "text" NOT NULL,
+	"asin" "text" NOT NULL,
+	"action" "text" NOT NULL,
+	"rule" "text",
+	"book" "json" NOT NULL,
+	"tags" "text"[],
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
+	"undone_at" timestamp with time zone
+);
+
+ALTER TABLE "public"."ban_undo_log" OWNER TO "postgres";
+
+ALTER TABLE "public"."ban_undo_log" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."ban_undo_log_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
 CREATE TABLE IF NOT EXISTS "public"."banned_tags" (
 	"id" bigint NOT NULL,
 	"tag" "text"
@@ -118,6 +226,79 @@ ALTER TABLE "public"."banned_words" ALTER COLUMN "id" ADD GENERATED BY DEFAULT A
 	CACHE 1
 );
 
+CREATE TABLE IF NOT EXISTS "public"."book_relations" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"related_asin" "text" NOT NULL,
+	"relation" "text" NOT NULL,
+	"position" integer,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."book_relations" OWNER TO "postgres";
+
+ALTER TABLE "public"."book_relations" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."book_relations_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE OR REPLACE VIEW "public"."book_relation_counts" AS
+ SELECT "book_relations"."related_asin" AS "asin",
+	"count"(DISTINCT "book_relations"."asin") AS "related_from",
+	"sum"((1.0 / (GREATEST("book_relations"."position", 1))::numeric)) AS "score"
+   FROM "public"."book_relations"
+  GROUP BY "book_relations"."related_asin";
+
+ALTER VIEW "public"."book_relation_counts" OWNER TO "postgres";
+
+CREATE TABLE IF NOT EXISTS "public"."book_reviews" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"position" integer NOT NULL,
+	"title" "text",
+	"body" "text",
+	"overall" real,
+	"performance" real,
+	"story" real,
+	"reviewed_on" "date",
+	"helpful" integer,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."book_reviews" OWNER TO "postgres";
+
+ALTER TABLE "public"."book_reviews" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."book_reviews_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."book_sightings" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"source" "text" NOT NULL,
+	"rank" integer,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."book_sightings" OWNER TO "postgres";
+
+ALTER TABLE "public"."book_sightings" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."book_sightings_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
 CREATE TABLE IF NOT EXISTS "public"."books" (
 	"id" "uuid" DEFAULT "extensions"."uuid_generate_v4"() NOT NULL,
 	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
@@ -129,6 +310,7 @@ CREATE TABLE IF NOT EXISTS "public"."books" (
 	"authorlink" "text",
 	"series" "text",
 	"serieslink" "text",
+	"seriesposition" real,
 	"format" "text",
 	"releasedate" "date",
 	"image" "text",
@@ -144,11 +326,342 @@ CREATE TABLE IF NOT EXISTS "public"."books" (
 	"ratingperformance" real,
 	"ratingstory" real,
 	"durationInMins" integer,
-	"popularity" real DEFAULT '0'::real
+	"popularity" real DEFAULT '0'::real,
+	"hidden" boolean DEFAULT false NOT NULL,
+	"language" "text",
+	"ranker" "text",
+	"category" "text",
+	"ratinggenre" real,
+	"ratinggenreprior" "text",
+	"laudscore" real,
+	"laudscoreexplain" "jsonb",
+	"suspect" boolean DEFAULT false NOT NULL,
+	"included_with_membership" boolean DEFAULT false NOT NULL
 );
 
 ALTER TABLE "public"."books" OWNER TO "postgres";
 
+CREATE TABLE IF NOT EXISTS "public"."covers" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"url" "text",
+	"sha256" "text" NOT NULL,
+	"format" "text",
+	"width" integer,
+	"height" integer,
+	"colour" "text",
+	"phash" "text",
+	"fetched_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."covers" OWNER TO "postgres";
+
+ALTER TABLE "public"."covers" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."covers_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."library" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"author" "text",
+	"owned" boolean DEFAULT false NOT NULL,
+	"finished" boolean DEFAULT false NOT NULL,
+	"personal_rating" real DEFAULT 0 NOT NULL,
+	"source" "text",
+	"updated_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."library" OWNER TO "postgres";
+
+ALTER TABLE "public"."library" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."library_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."list_sources" (
+	"id" bigint NOT NULL,
+	"name" "text" NOT NULL,
+	"url" "text" NOT NULL,
+	"pages" integer DEFAULT 1 NOT NULL,
+	"enabled" boolean DEFAULT true NOT NULL,
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."list_sources" OWNER TO "postgres";
+
+ALTER TABLE "public"."list_sources" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."list_sources_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."membership_history" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"included" boolean NOT NULL,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."membership_history" OWNER TO "postgres";
+
+ALTER TABLE "public"."membership_history" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."membership_history_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."preorders" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"category" "text",
+	"releasedate" "date",
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
+	"released_at" timestamp with time zone
+);
+
+ALTER TABLE "public"."preorders" OWNER TO "postgres";
+
+ALTER TABLE "public"."preorders" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."preorders_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."price_alerts" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"below" real DEFAULT 0 NOT NULL,
+	"on_sale" boolean DEFAULT false NOT NULL,
+	"fired_at" timestamp with time zone,
+	"fired_price" real,
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."price_alerts" OWNER TO "postgres";
+
+ALTER TABLE "public"."price_alerts" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."price_alerts_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."price_history" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"currency" "text",
+	"list_price" real,
+	"member_price" real,
+	"sale_price" real,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."price_history" OWNER TO "postgres";
+
+ALTER TABLE "public"."price_history" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."price_history_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."rating_history" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"ratings" integer NOT NULL,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."rating_history" OWNER TO "postgres";
+
+ALTER TABLE "public"."rating_history" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."rating_history_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."review_signals" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"reviews" integer,
+	"avg_words" real,
+	"keywords" "text"[],
+	"generic" real,
+	"updated_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."review_signals" OWNER TO "postgres";
+
+ALTER TABLE "public"."review_signals" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."review_signals_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."revisits" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"category" "text",
+	"attempts" integer DEFAULT 0 NOT NULL,
+	"next_visit" timestamp with time zone,
+	"last_visit" timestamp with time zone,
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
+	"done_at" timestamp with time zone
+);
+
+ALTER TABLE "public"."revisits" OWNER TO "postgres";
+
+ALTER TABLE "public"."revisits" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."revisits_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."saved_queries" (
+	"id" bigint NOT NULL,
+	"name" "text" NOT NULL,
+	"tags" "text"[],
+	"min_rating" real,
+	"min_duration" integer,
+	"max_duration" integer,
+	"released_after" "date",
+	"released_before" "date",
+	"include_suspect" boolean DEFAULT false NOT NULL,
+	"hide_owned" boolean DEFAULT false NOT NULL,
+	"included_with_membership" boolean DEFAULT false NOT NULL,
+	"inserted_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."saved_queries" OWNER TO "postgres";
+
+ALTER TABLE "public"."saved_queries" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."saved_queries_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."series_entries" (
+	"id" bigint NOT NULL,
+	"series_id" "text" NOT NULL,
+	"series" "text",
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"position" real,
+	"seen_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."series_entries" OWNER TO "postgres";
+
+ALTER TABLE "public"."series_entries" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."series_entries_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."skipped_books" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"reason" "text" NOT NULL,
+	"match" "text",
+	"category" "text",
+	"source" "text",
+	"first_seen" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
+	"last_seen" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."skipped_books" OWNER TO "postgres";
+
+ALTER TABLE "public"."skipped_books" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."skipped_books_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."suspect_books" (
+	"id" bigint NOT NULL,
+	"asin" "text" NOT NULL,
+	"title" "text",
+	"risk" real NOT NULL,
+	"signals" "jsonb",
+	"status" "text" DEFAULT 'pending'::"text" NOT NULL,
+	"flagged_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL,
+	"reviewed_at" timestamp with time zone
+);
+
+ALTER TABLE "public"."suspect_books" OWNER TO "postgres";
+
+ALTER TABLE "public"."suspect_books" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."suspect_books_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
+CREATE TABLE IF NOT EXISTS "public"."tag_priors" (
+	"id" bigint NOT NULL,
+	"tag" "text" NOT NULL,
+	"alpha" "json" NOT NULL,
+	"books" integer,
+	"updated_at" timestamp with time zone DEFAULT "timezone"('utc'::"text", "now"()) NOT NULL
+);
+
+ALTER TABLE "public"."tag_priors" OWNER TO "postgres";
+
+ALTER TABLE "public"."tag_priors" ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (
+	SEQUENCE NAME "public"."tag_priors_id_seq"
+	START WITH 1
+	INCREMENT BY 1
+	NO MINVALUE
+	NO MAXVALUE
+	CACHE 1
+);
+
 CREATE TABLE IF NOT EXISTS "public"."tags" (
 	"id" bigint NOT NULL,
 	"tag" "text" NOT NULL,
@@ -166,21 +679,151 @@ ALTER TABLE "public"."tags" ALTER COLUMN "id" ADD GENERATED ALWAYS AS IDENTITY (
 	CACHE 1
 );
 
+ALTER TABLE ONLY "public"."ban_rules"
+	ADD CONSTRAINT "ban_rules_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."ban_undo_log"
+	ADD CONSTRAINT "ban_undo_log_pkey" PRIMARY KEY ("id");
+
 ALTER TABLE ONLY "public"."banned_tags"
 	ADD CONSTRAINT "banned_pkey" PRIMARY KEY ("id");
 
 ALTER TABLE ONLY "public"."banned_words"
 	ADD CONSTRAINT "banned_words_pkey" PRIMARY KEY ("id");
 
+ALTER TABLE ONLY "public"."book_relations"
+	ADD CONSTRAINT "book_relations_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."book_relations"
+	ADD CONSTRAINT "book_relations_asin_related_asin_relation_key" UNIQUE ("asin", "related_asin", "relation");
+
+ALTER TABLE ONLY "public"."book_reviews"
+	ADD CONSTRAINT "book_reviews_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."book_reviews"
+	ADD CONSTRAINT "book_reviews_asin_position_key" UNIQUE ("asin", "position");
+
+ALTER TABLE ONLY "public"."book_sightings"
+	ADD CONSTRAINT "book_sightings_pkey" PRIMARY KEY ("id");
+
 ALTER TABLE ONLY "public"."books"
 	ADD CONSTRAINT "books_pkey" PRIMARY KEY ("id");
 
+ALTER TABLE ONLY "public"."covers"
+	ADD CONSTRAINT "covers_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."covers"
+	ADD CONSTRAINT "covers_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."library"
+	ADD CONSTRAINT "library_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."library"
+	ADD CONSTRAINT "library_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."list_sources"
+	ADD CONSTRAINT "list_sources_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."list_sources"
+	ADD CONSTRAINT "list_sources_name_key" UNIQUE ("name");
+
+ALTER TABLE ONLY "public"."membership_history"
+	ADD CONSTRAINT "membership_history_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."preorders"
+	ADD CONSTRAINT "preorders_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."preorders"
+	ADD CONSTRAINT "preorders_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."price_alerts"
+	ADD CONSTRAINT "price_alerts_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."price_alerts"
+	ADD CONSTRAINT "price_alerts_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."price_history"
+	ADD CONSTRAINT "price_history_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."rating_history"
+	ADD CONSTRAINT "rating_history_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."review_signals"
+	ADD CONSTRAINT "review_signals_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."review_signals"
+	ADD CONSTRAINT "review_signals_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."revisits"
+	ADD CONSTRAINT "revisits_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."revisits"
+	ADD CONSTRAINT "revisits_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."saved_queries"
+	ADD CONSTRAINT "saved_queries_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."saved_queries"
+	ADD CONSTRAINT "saved_queries_name_key" UNIQUE ("name");
+
+ALTER TABLE ONLY "public"."series_entries"
+	ADD CONSTRAINT "series_entries_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."series_entries"
+	ADD CONSTRAINT "series_entries_series_id_asin_key" UNIQUE ("series_id", "asin");
+
+ALTER TABLE ONLY "public"."skipped_books"
+	ADD CONSTRAINT "skipped_books_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."skipped_books"
+	ADD CONSTRAINT "skipped_books_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."suspect_books"
+	ADD CONSTRAINT "suspect_books_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."suspect_books"
+	ADD CONSTRAINT "suspect_books_asin_key" UNIQUE ("asin");
+
+ALTER TABLE ONLY "public"."tag_priors"
+	ADD CONSTRAINT "tag_priors_pkey" PRIMARY KEY ("id");
+
+ALTER TABLE ONLY "public"."tag_priors"
+	ADD CONSTRAINT "tag_priors_tag_key" UNIQUE ("tag");
+
 ALTER TABLE ONLY "public"."tags"
 	ADD CONSTRAINT "tags_pkey" PRIMARY KEY ("id");
 
 ALTER TABLE ONLY "public"."tags"
 	ADD CONSTRAINT "tags_tag_asin_key" UNIQUE ("tag", "asin");
 
+CREATE INDEX "idx_ban_undo_log_batch" ON "public"."ban_undo_log" USING "btree" ("batch");
+
+CREATE INDEX "idx_book_relations_related_asin" ON "public"."book_relations" USING "btree" ("related_asin");
+
+CREATE INDEX "idx_book_sightings_asin" ON "public"."book_sightings" USING "btree" ("asin");
+
+CREATE INDEX "idx_book_sightings_source" ON "public"."book_sightings" USING "btree" ("source");
+
 CREATE INDEX "idx_books_asin" ON "public"."books" USING "btree" ("asin");
 
+CREATE INDEX "idx_books_inserted_at" ON "public"."books" USING "btree" ("inserted_at");
+
+CREATE INDEX "idx_covers_sha256" ON "public"."covers" USING "btree" ("sha256");
+
+CREATE INDEX "idx_membership_history_asin" ON "public"."membership_history" USING "btree" ("asin");
+
+CREATE INDEX "idx_preorders_releasedate" ON "public"."preorders" USING "btree" ("releasedate");
+
+CREATE INDEX "idx_price_history_asin" ON "public"."price_history" USING "btree" ("asin");
+
+CREATE INDEX "idx_rating_history_asin" ON "public"."rating_history" USING "btree" ("asin");
+
+CREATE INDEX "idx_revisits_next_visit" ON "public"."revisits" USING "btree" ("next_visit");
+
+CREATE INDEX "idx_skipped_books_reason" ON "public"."skipped_books" USING "btree" ("reason");
+
+CREATE INDEX "idx_suspect_books_status" ON "public"."suspect_books" USING "btree" ("status");
+
 CREATE INDEX "idx_tags_tag" ON "public"."tags" USING "btree" ("tag");
+
+CREATE OR REPLACE TRIGGER "suspect_books_status" AFTER INSERT OR UPDATE OF "status" ON "public"."suspect_books" FOR EACH ROW EXECUTE FUNCTION "public"."sync_suspect_status"();
@@ -0,0 +1,210 @@
+// series.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Series
+//
+// We often have book 1 and book 4 of a series but not 2 and 3, because only
+// some of them made it onto a category list. The series list source reads the
+// series page (from serieslink) of every series with a book rated at least
+// SERIES_MIN_RATING (default 4.3), and each entry goes through the normal list
+// filters, so we pick up the rest:
+//
+// 	laud crawl --source series
+//
+// Each entry on a series page is recorded in series_entries with its position,
+// and books get their seriesposition from the product page. series-gaps then
+// reports the series with gaps, and what happened to the missing entries:
+//
+// 	laud series-gaps [--series "The Stormlight Archive"]
+
+// 'Series: The Stormlight Archive, Book 1', or just 'Book 1' on a series page
+var findSeriesPositionRx = regexp.MustCompile(`(?i),\s*Book\s+(\d+(?:\.\d+)?)`)
+var findBookPositionRx = regexp.MustCompile(`(?i)\bBook\s+(\d+(?:\.\d+)?)\b`)
+
+// pages of entries to read on a series page, we stop early when they run out
+const seriesPagesToFetch = 5
+
+func findSeriesPosition(text string) float64 {
+	m := findSeriesPositionRx.FindStringSubmatch(text)
+	if len(m) == 0 {
+		return 0
+	}
+	position, _ := strconv.ParseFloat(m[1], 64)
+	return position
+}
+
+type seriesSource struct {
+	series string
+	link   string
+}
+
+func (s seriesSource) Name() string {
+	return "series/" + path.Base(s.link)
+}
+
+func (s seriesSource) Friendly() string {
+	return "the " + s.series + " series"
+}
+
+func (s seriesSource) Category() Category  { return "" }
+func (s seriesSource) Sort() Sort          { return sortFeatured }
+func (s seriesSource) Pages() int          { return seriesPagesToFetch }
+func (s seriesSource) URL(page int) string { return pageURL(s.link, page) }
+
+func (bc *BookCollector) seriesSources() []ListSource {
+	minRating := envFloat("SERIES_MIN_RATING", 4.3)
+
+	byLink := map[string]string{}
+	err := forEachRow(bc.db, "books", "asin,series,serieslink,rating,hidden", func(b Book) {
+		if b.Hidden || b.SeriesLink == "" || b.Rating < minRating {
+			return
+		}
+		if link, err := absoluteLink(b.SeriesLink); err == nil {
+			byLink[link] = b.Series
+		}
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	log.Printf("INFO: %d series with a book rated %g\n", len(byLink), minRating)
+
+	sources := []ListSource{}
+	for link, series := range byLink {
+		sources = append(sources, seriesSource{series: series, link: link})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name() < sources[j].Name() })
+	return sources
+}
+
+type seriesEntry struct {
+	SeriesId string    `json:"series_id"`
+	Series   string    `json:"series"`
+	Id       string    `json:"asin"`
+	Title    string    `json:"title"`
+	Position float64   `json:"position"`
+	SeenAt   time.Time `json:"seen_at"`
+}
+
+// when we're reading a series page, every book on it is an entry
+func (bc *BookCollector) addSeriesEntryToDB(id, title, productText string) {
+	s, ok := bc.currentSource.(seriesSource)
+	if !ok || id == "" {
+		return
+	}
+	position := findSeriesPosition(productText)
+	if position == 0 {
+		if m := findBookPositionRx.FindStringSubmatch(productText); len(m) > 0 {
+			position, _ = strconv.ParseFloat(m[1], 64)
+		}
+	}
+	entry := seriesEntry{
+		SeriesId: path.Base(s.link),
+		Series:   s.series,
+		Id:       id,
+		Title:    title,
+		Position: position,
+		SeenAt:   time.Now().UTC(),
+	}
+	_, _, err := bc.db.From("series_entries").Upsert(entry, "series_id,asin", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: series_entries: id:%s %s", id, err)
+	}
+}
+
+// what happened to an entry we don't have
+func entryStatus(id string, books map[string]Book, skipped map[string]skippedBook) string {
+	if b, ok := books[id]; ok {
+		if b.Hidden {
+			return "hidden"
+		}
+		return "have"
+	}
+	if s, ok := skipped[id]; ok {
+		if s.Match != "" {
+			return fmt.Sprintf("skipped: %s '%s'", s.Reason, s.Match)
+		}
+		return "skipped: " + string(s.Reason)
+	}
+	return "not fetched"
+}
+
+func (bc *BookCollector) seriesGaps(args []string) {
+	flags := flag.NewFlagSet("series-gaps", flag.ExitOnError)
+	only := flags.String("series", "", "just this series")
+	flags.Parse(args)
+
+	entries := map[string][]seriesEntry{}
+	err := forEachRow(bc.db, "series_entries", "series_id,series,asin,title,position", func(e seriesEntry) {
+		if *only == "" || strings.EqualFold(e.Series, *only) {
+			entries[e.SeriesId] = append(entries[e.SeriesId], e)
+		}
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	books := map[string]Book{}
+	err = forEachRow(bc.db, "books", "asin,title,hidden", func(b Book) {
+		books[b.Id] = b
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	skipped := map[string]skippedBook{}
+	err = forEachRow(bc.db, "skipped_books", "asin,title,reason,match", func(s skippedBook) {
+		skipped[s.Id] = s
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+
+	ids := []string{}
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return entries[ids[i]][0].Series < entries[ids[j]][0].Series })
+
+	withGaps := 0
+	for _, id := range ids {
+		series := entries[id]
+		sort.Slice(series, func(i, j int) bool { return series[i].Position < series[j].Position })
+
+		lines := []string{}
+		positions := map[int]bool{}
+		for _, e := range series {
+			positions[int(e.Position)] = true
+			if status := entryStatus(e.Id, books, skipped); status != "have" {
+				lines = append(lines, fmt.Sprintf("- - %g %s %s: %s", e.Position, e.Id, e.Title, status))
+			}
+		}
+		// numbered books that aren't even on the series page
+		last := int(math.Floor(series[len(series)-1].Position))
+		for position := 1; position < last; position++ {
+			if !positions[position] {
+				lines = append(lines, fmt.Sprintf("- - %d: not on the series page", position))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		withGaps++
+		log.Printf("- SERIES: %s (%d entries)\n", series[0].Series, len(series))
+		for _, line := range lines {
+			log.Println(line)
+		}
+	}
+	log.Printf("SERIES: %d of %d series have gaps\n", withGaps, len(ids))
+}
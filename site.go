@@ -0,0 +1,483 @@
+// site.go
+
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Static site
+//
+// The point of all this is to browse good books on a phone without the
+// Audible app, so the site command renders the catalogue as a static website
+// that can be put anywhere (GitHub Pages, S3, a folder on a NAS):
+//
+// 	laud site --out public --top 50 --min-rating 4
+//
+// 	index.html            overall leaderboards, and links to every category and tag
+// 	category/<name>.html  leaderboards for each category
+// 	tag/<name>.html       leaderboards for each tag
+// 	author/<name>.html    every book by an author
+// 	series/<name>.html    every book in a series, in order
+// 	book/<asin>.html      cover, sample, histograms and a link back to Audible
+// 	feeds/…               Atom and RSS feeds of new books, see feeds.go
+//
+// Leaderboards are by weighted rating and by popularity. It takes the same
+// filters as export, and the templates are in templates/, built into laud.
+
+//go:embed templates
+var siteTemplates embed.FS
+
+// reviews shown on each book's page
+const siteReviews = 3
+
+// the book columns the site is made from, including everything
+// bookFilter.match reads, for the saved queries
+const siteBookColumns = "asin,title,subtitle,author,authorlink,series,serieslink,seriesposition,format,releasedate,image,sample,link,summary," +
+	"durationInMins,language,ratingsoverall,ratingsperformance,ratingsstory,rating,ratingperformance,ratingstory," +
+	"popularity,laudscore,category,inserted_at,included_with_membership,hidden,suspect"
+
+type siteBook struct {
+	Book
+	InsertedAt    time.Time `json:"inserted_at"`
+	Ratings       int       `json:"-"`
+	AudibleRating float64   `json:"-"`
+	BookTags      []string  `json:"-"`
+	// nil if it's not in our library
+	Library *libraryBook `json:"-"`
+	// the first few, and nil if we've not read any
+	Reviews       []bookReview   `json:"-"`
+	ReviewSignals *reviewSignals `json:"-"`
+	// local copies of the cover, relative to the site, and "" to use Image
+	Thumb  string `json:"-"`
+	Cover  string `json:"-"`
+	Colour string `json:"-"`
+	// the author, series and tag pages, see uniqueSlugs
+	AuthorSlug string     `json:"-"`
+	SeriesSlug string     `json:"-"`
+	TagLinks   []siteLink `json:"-"`
+}
+
+type leaderboard struct {
+	ByRating     []*siteBook
+	ByPopularity []*siteBook
+	// by rating, of those included with membership
+	Included []*siteBook
+	// by position, for series
+	InOrder []*siteBook
+}
+
+type sitePage struct {
+	Title       string
+	Generated   time.Time
+	Ranker      string
+	Leaderboard leaderboard
+	Categories  []string
+	Tags        []siteLink
+	Book        *siteBook
+	Queries     []siteLink
+	// the page's feed, e.g. tag/fantasy
+	Feed string
+}
+
+// a tag or saved query, and the name of its page and feeds
+type siteLink struct {
+	Name string
+	Slug string
+}
+
+type histogramBar struct {
+	Stars   int
+	Count   int
+	Percent float64
+}
+
+type histogramData struct {
+	Name   string
+	Rating float64
+	Total  int
+	Bars   []histogramBar
+}
+
+func histogram(name string, rating float64, ratings []string) histogramData {
+	h := histogramData{Name: name, Rating: rating}
+	ns, err := stringsToInts(ratings)
+	if err != nil || checkHistogram(ns) != nil {
+		return h
+	}
+	h.Total = sum(ns)
+	for i, n := range ns {
+		bar := histogramBar{Stars: stars[i], Count: n}
+		if h.Total > 0 {
+			bar.Percent = 100 * float64(n) / float64(h.Total)
+		}
+		h.Bars = append(h.Bars, bar)
+	}
+	return h
+}
+
+var slugRx = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// file names for tags, authors etc
+func slug(s string) string {
+	s = strings.Trim(slugRx.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// slugs for names that are all different, as 'A.J. Smith' and 'A J Smith'
+// would both be a-j-smith. The names are taken in order, and the first gets
+// the plain slug, so the same names get the same slugs every time.
+func uniqueSlugs(names []string) map[string]string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	slugs := map[string]string{}
+	taken := map[string]bool{}
+	for _, name := range sorted {
+		if _, ok := slugs[name]; ok {
+			continue
+		}
+		s := slug(name)
+		for n := 2; taken[s]; n++ {
+			s = fmt.Sprintf("%s-%d", slug(name), n)
+		}
+		taken[s] = true
+		slugs[name] = s
+	}
+	return slugs
+}
+
+func formatDuration(mins int) string {
+	if mins < 60 {
+		return fmt.Sprintf("%d mins", mins)
+	}
+	return fmt.Sprintf("%d hrs %d mins", mins/60, mins%60)
+}
+
+// pages in subdirectories link back up with root
+func parseSiteTemplates(root string, page string) *template.Template {
+	funcs := template.FuncMap{
+		"root":      func() string { return root },
+		"slug":      slug,
+		"stars":     func(rating float64) string { return fmt.Sprintf("%.2f", rating) },
+		"duration":  formatDuration,
+		"histogram": histogram,
+		"join":      strings.Join,
+		// the summary is Audible's own HTML
+		"summary": func(s string) template.HTML { return template.HTML(s) },
+	}
+	return template.Must(template.New(page).Funcs(funcs).ParseFS(siteTemplates, "templates/layout.html", "templates/"+page))
+}
+
+func topBooks(books []*siteBook, top int) leaderboard {
+	board := leaderboard{
+		ByRating:     append([]*siteBook{}, books...),
+		ByPopularity: append([]*siteBook{}, books...),
+	}
+	sort.SliceStable(board.ByRating, func(i, j int) bool { return board.ByRating[i].Rating > board.ByRating[j].Rating })
+	sort.SliceStable(board.ByPopularity, func(i, j int) bool {
+		return board.ByPopularity[i].PopularityScore > board.ByPopularity[j].PopularityScore
+	})
+	for _, b := range board.ByRating {
+		if b.IncludedMembership && (top == 0 || len(board.Included) < top) {
+			board.Included = append(board.Included, b)
+		}
+	}
+	if top > 0 && len(books) > top {
+		board.ByRating = board.ByRating[:top]
+		board.ByPopularity = board.ByPopularity[:top]
+	}
+	return board
+}
+
+// a series in reading order, books without a position at the end
+func seriesBooks(books []*siteBook) leaderboard {
+	board := topBooks(books, 0)
+	board.InOrder = append([]*siteBook{}, books...)
+	sort.SliceStable(board.InOrder, func(i, j int) bool {
+		a, b := board.InOrder[i], board.InOrder[j]
+		if (a.SeriesPosition == 0) != (b.SeriesPosition == 0) {
+			return b.SeriesPosition == 0
+		}
+		if a.SeriesPosition != b.SeriesPosition {
+			return a.SeriesPosition < b.SeriesPosition
+		}
+		return a.ReleaseDate.Before(b.ReleaseDate.Time)
+	})
+	return board
+}
+
+func writePage(t *template.Template, path string, page sitePage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.ExecuteTemplate(f, "layout", page)
+}
+
+// the category's tags, which we add to every book found in it
+func hasAllTags(tags []string, want []string) bool {
+	f := bookFilter{tags: want}
+	return f.matchTags(tags)
+}
+
+func copyFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0644)
+}
+
+// copies each book's thumbnails from laud covers into the site, they're named
+// by their hash so any already there are the same
+func copyCovers(dir, out string, books []*siteBook, covers map[string]cover) (int, error) {
+	copied := 0
+	for _, b := range books {
+		c, ok := covers[b.Id]
+		if !ok {
+			continue
+		}
+		paths := []string{}
+		for _, width := range []int{coverThumbSize, coverPageSize} {
+			from := c.path(dir, width)
+			if _, err := os.Stat(from); err != nil {
+				break
+			}
+			rel, err := filepath.Rel(dir, from)
+			if err != nil {
+				return copied, err
+			}
+			to := filepath.Join(out, "covers", rel)
+			if _, err := os.Stat(to); err != nil {
+				if err := copyFile(from, to); err != nil {
+					return copied, err
+				}
+			}
+			paths = append(paths, "covers/"+filepath.ToSlash(rel))
+		}
+		if len(paths) < 2 {
+			continue
+		}
+		b.Thumb, b.Cover, b.Colour = paths[0], paths[1], c.Colour
+		copied++
+	}
+	return copied, nil
+}
+
+func (bc *BookCollector) site(args []string) {
+	flags := flag.NewFlagSet("site", flag.ExitOnError)
+	out := flags.String("out", "site", "directory to write the site to")
+	top := flags.Int("top", 50, "books in each leaderboard, 0 for all of them")
+	baseURL := flags.String("base-url", os.Getenv("SITE_URL"), "where the site will be, for links in feeds")
+	feedSize := flags.Int("feed-size", 50, "newest books in each feed")
+	coversDir := flags.String("covers", defaultCoversDir, "where laud covers keeps covers, to use instead of Audible's")
+	filter := bookFilter{}
+	filter.addFlags(flags)
+	flags.Parse(args)
+
+	library := bc.loadLibrary()
+	filter.owned = ownedBooks(library)
+	books := []*siteBook{}
+	err := forEachPage(bc.db, "books", siteBookColumns, filter.where, func(page []siteBook) error {
+		ids := make([]string, len(page))
+		for i, b := range page {
+			ids[i] = b.Id
+		}
+		tags, err := loadTagsFor(bc.db, ids)
+		if err != nil {
+			return err
+		}
+		reviews, signals, err := loadReviewsFor(bc.db, ids, siteReviews)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			b := &page[i]
+			if !filter.matchLocal(b.Id, tags[b.Id]) {
+				continue
+			}
+			b.BookTags = tags[b.Id]
+			b.Reviews = reviews[b.Id]
+			b.ReviewSignals = signals[b.Id]
+			if l, ok := library[b.Id]; ok {
+				b.Library = &l
+			}
+			if ns, err := stringsToInts(b.RatingsOverall); err == nil {
+				b.Ratings = sum(ns)
+				b.AudibleRating, _ = MeanRanker{}.Rank(ns)
+			}
+			books = append(books, b)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal("ERR!: SITE:", err)
+	}
+
+	// the pages and feeds use the local covers, so they have to be there first
+	copied, err := copyCovers(*coversDir, *out, books, loadCovers(bc.db))
+	if err != nil {
+		log.Fatal("ERR!: SITE:", err)
+	}
+	log.Printf("INFO: %d of %d covers are local\n", copied, len(books))
+
+	byTag := map[string][]*siteBook{}
+	byAuthor := map[string][]*siteBook{}
+	bySeries := map[string][]*siteBook{}
+	for _, b := range books {
+		for _, tag := range b.BookTags {
+			byTag[tag] = append(byTag[tag], b)
+		}
+		if b.Author != "" {
+			byAuthor[b.Author] = append(byAuthor[b.Author], b)
+		}
+		if b.Series != "" {
+			bySeries[b.Series] = append(bySeries[b.Series], b)
+		}
+	}
+	tags := []string{}
+	for tag := range byTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	authors := []string{}
+	for author := range byAuthor {
+		authors = append(authors, author)
+	}
+	seriesNames := []string{}
+	for series := range bySeries {
+		seriesNames = append(seriesNames, series)
+	}
+	authorSlugs := uniqueSlugs(authors)
+	seriesSlugs := uniqueSlugs(seriesNames)
+	tagSlugs := uniqueSlugs(tags)
+	for _, b := range books {
+		b.AuthorSlug = authorSlugs[b.Author]
+		b.SeriesSlug = seriesSlugs[b.Series]
+		for _, tag := range b.BookTags {
+			b.TagLinks = append(b.TagLinks, siteLink{tag, tagSlugs[tag]})
+		}
+	}
+	tagLinks := []siteLink{}
+	for _, tag := range tags {
+		tagLinks = append(tagLinks, siteLink{tag, tagSlugs[tag]})
+	}
+	categoryNames := []string{}
+	for _, c := range categories {
+		categoryNames = append(categoryNames, c.Friendly())
+	}
+
+	now := time.Now()
+	index := parseSiteTemplates("", "index.html")
+	list := parseSiteTemplates("../", "list.html")
+	book := parseSiteTemplates("../", "book.html")
+	page := func(title string) sitePage {
+		return sitePage{Title: title, Generated: now, Ranker: bc.ranker.Name()}
+	}
+	write := func(t *template.Template, path string, p sitePage) {
+		if err := writePage(t, filepath.Join(*out, path), p); err != nil {
+			log.Fatal("ERR!: SITE:", err)
+		}
+	}
+	feeds := newFeedWriter(filepath.Join(*out, "feeds"), *baseURL, *feedSize, now)
+	writeFeed := func(title, path string, books []*siteBook) {
+		if err := feeds.write(title, path, books); err != nil {
+			log.Fatal("ERR!: SITE:", err)
+		}
+	}
+
+	queries := bc.loadSavedQueries()
+	queryNames := []string{}
+	for _, q := range queries {
+		queryNames = append(queryNames, q.Name)
+	}
+	querySlugs := uniqueSlugs(queryNames)
+	queryLinks := []siteLink{}
+	for _, q := range queries {
+		matching := []*siteBook{}
+		f := q.filter()
+		f.owned = filter.owned
+		for _, b := range books {
+			if f.match(&b.Book, b.BookTags) {
+				matching = append(matching, b)
+			}
+		}
+		writeFeed(q.Name, "query/"+querySlugs[q.Name], matching)
+		queryLinks = append(queryLinks, siteLink{q.Name, querySlugs[q.Name]})
+	}
+
+	p := page("Top Books")
+	p.Leaderboard = topBooks(books, *top)
+	p.Categories = categoryNames
+	p.Tags = tagLinks
+	p.Queries = queryLinks
+	write(index, "index.html", p)
+
+	for _, c := range categories {
+		inCategory := []*siteBook{}
+		for _, b := range books {
+			if b.Category == c || hasAllTags(b.BookTags, c.Tags()) {
+				inCategory = append(inCategory, b)
+			}
+		}
+		p := page(c.Friendly())
+		p.Leaderboard = topBooks(inCategory, *top)
+		p.Feed = feedPath("category", c.Friendly())
+		write(list, p.Feed+".html", p)
+		writeFeed(p.Title, p.Feed, inCategory)
+	}
+	for tag, tagged := range byTag {
+		p := page(tag)
+		p.Leaderboard = topBooks(tagged, *top)
+		p.Feed = "tag/" + tagSlugs[tag]
+		write(list, p.Feed+".html", p)
+		writeFeed(p.Title, p.Feed, tagged)
+	}
+	// everything by an author, or in a series, not just the top few
+	for author, theirs := range byAuthor {
+		p := page(author)
+		p.Leaderboard = topBooks(theirs, 0)
+		p.Feed = "author/" + authorSlugs[author]
+		write(list, p.Feed+".html", p)
+		writeFeed(p.Title, p.Feed, theirs)
+	}
+	for series, inSeries := range bySeries {
+		p := page(series)
+		p.Leaderboard = seriesBooks(inSeries)
+		write(list, filepath.Join("series", seriesSlugs[series]+".html"), p)
+	}
+	for _, b := range books {
+		p := page(b.Title)
+		p.Book = b
+		write(book, filepath.Join("book", b.Id+".html"), p)
+	}
+
+	css, err := siteTemplates.ReadFile("templates/style.css")
+	if err != nil {
+		log.Fatal("ERR!: SITE:", err)
+	}
+	if err := os.WriteFile(filepath.Join(*out, "style.css"), css, 0644); err != nil {
+		log.Fatal("ERR!: SITE:", err)
+	}
+	log.Printf("SITE: %d books, %d tags, %d authors, %d series, %d saved queries in %s\n", len(books), len(tags), len(byAuthor), len(bySeries), len(queries), *out)
+}
@@ -0,0 +1,76 @@
+// skipped.go
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+)
+
+// Skipped books
+//
+// Every time a filter throws a book away we record why in skipped_books, so we
+// can audit the filters, spot false positives and find out why a book we know
+// exists never turns up in the database.
+//
+// One row per asin: first_seen is set by the database on insert, last_seen,
+// reason and match are overwritten every time the book is skipped again.
+
+type SkipReason string
+
+const (
+	skipLanguage   SkipReason = "language"
+	skipPreOrder   SkipReason = "pre-order"
+	skipNotRated   SkipReason = "not-rated"
+	skipBannedWord SkipReason = "banned-word"
+	skipBannedTag  SkipReason = "banned-tag"
+	skipBanRule    SkipReason = "ban-rule"
+)
+
+type skippedBook struct {
+	Id       string     `json:"asin"`
+	Title    string     `json:"title"`
+	Reason   SkipReason `json:"reason"`
+	Match    string     `json:"match"`
+	Category Category   `json:"category"`
+	Source   string     `json:"source"`
+	LastSeen time.Time  `json:"last_seen"`
+}
+
+func (bc *BookCollector) addSkippedBookToDB(id, title string, reason SkipReason, match string) {
+	// without an asin there's nothing to key the row on
+	if id == "" {
+		return
+	}
+	sb := skippedBook{
+		Id:       id,
+		Title:    title,
+		Reason:   reason,
+		Match:    match,
+		Category: bc.currentCategory,
+		Source:   bc.sourceName(),
+		LastSeen: time.Now().UTC(),
+	}
+	_, _, err := bc.db.From("skipped_books").Upsert(sb, "asin", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: skipped_books: id:%s %s", id, err)
+	}
+	// crawl --new keeps track, so it knows what it's seen
+	if bc.skipped != nil {
+		bc.skipped[id] = true
+	}
+}
+
+// every book we've ever skipped, by asin
+func loadSkippedIds(db *supabase.Client) map[string]bool {
+	skipped := map[string]bool{}
+	err := forEachRow(db, "skipped_books", "asin", func(s skippedBook) {
+		skipped[s.Id] = true
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return skipped
+}
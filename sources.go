@@ -0,0 +1,207 @@
+// sources.go
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// List sources
+//
+// A ListSource is anywhere with a list of books: the category searches, but
+// also best-seller charts, new releases, daily deals and sale events. They all
+// go through the same list filters and product page scraping, and every book
+// on them is recorded in book_sightings, with the source's name and its place
+// on the list, so we know where it was seen.
+//
+// 	laud crawl                                     # the category searches, split up if they're big
+// 	laud crawl --source bestsellers --source new-releases
+// 	laud crawl --source authors                    # see authors.go
+// 	laud crawl --source series                     # see series.go
+// 	laud crawl --source saved                      # everything in list_sources
+// 	laud crawl --source summer-sale                # one of them
+//
+// Sale events and deals come and go, so they're rows in list_sources (name,
+// url, pages). Their pages need to look like the search results, with a
+// .productListItem per book, and they don't add any category tags.
+
+type ListSource interface {
+	// stored with everything seen on it, e.g. search/19378443031/review-rank
+	Name() string
+	Friendly() string
+	// whose tags we add to every book seen, or "" for none
+	Category() Category
+	// sortPop lists score popularity, the rest don't
+	Sort() Sort
+	Pages() int
+	URL(page int) string
+}
+
+// adds page and pageSize to a list URL
+func pageURL(url string, page int) string {
+	if page < 1 {
+		return url
+	}
+	if !strings.Contains(url, "?") {
+		url += "?"
+	} else if !strings.HasSuffix(url, "?") && !strings.HasSuffix(url, "&") {
+		url += "&"
+	}
+	return url + fmt.Sprintf("pageSize=%d&page=%d", pageSize, page)
+}
+
+//
+// Category search
+//
+
+type categorySearch struct {
+	category Category
+	sort     Sort
+}
+
+func (s categorySearch) Name() string {
+	return fmt.Sprintf("search/%s/%s", s.category, s.sort)
+}
+
+func (s categorySearch) Friendly() string {
+	return fmt.Sprintf("%s sorted by %s", s.category.Friendly(), s.sort.Friendly())
+}
+
+func (s categorySearch) Category() Category  { return s.category }
+func (s categorySearch) Sort() Sort          { return s.sort }
+func (s categorySearch) Pages() int          { return pagesToFetch }
+func (s categorySearch) URL(page int) string { return makeSearchUrl(s.category, s.sort, page) }
+
+//
+// Charts
+//
+// Audible's own lists for each category, in their order, which isn't ours
+// to score popularity with
+//
+
+const baseBestSellersUrl = "https://www.audible.co.uk/adblbestsellers?"
+const baseNewReleasesUrl = "https://www.audible.co.uk/newreleases?"
+
+type chart struct {
+	name     string
+	baseUrl  string
+	category Category
+}
+
+func (c chart) Name() string {
+	return fmt.Sprintf("%s/%s", c.name, c.category)
+}
+
+func (c chart) Friendly() string {
+	return fmt.Sprintf("%s %s", c.category.Friendly(), c.name)
+}
+
+func (c chart) Category() Category { return c.category }
+func (c chart) Sort() Sort         { return sortFeatured }
+func (c chart) Pages() int         { return pagesToFetch }
+
+func (c chart) URL(page int) string {
+	return pageURL(c.baseUrl+"node="+string(c.category), page)
+}
+
+//
+// Saved sources
+//
+
+type savedSource struct {
+	SourceName string `json:"name"`
+	Url        string `json:"url"`
+	PageCount  int    `json:"pages"`
+}
+
+func (s savedSource) Name() string        { return s.SourceName }
+func (s savedSource) Friendly() string    { return s.SourceName }
+func (s savedSource) Category() Category  { return "" }
+func (s savedSource) Sort() Sort          { return sortFeatured }
+func (s savedSource) Pages() int          { return s.PageCount }
+func (s savedSource) URL(page int) string { return pageURL(s.Url, page) }
+
+func (bc *BookCollector) loadSavedSources() []savedSource {
+	sources := []savedSource{}
+	_, err := bc.db.From("list_sources").Select("name,url,pages", "", false).Eq("enabled", "true").Order("name", ascending).ExecuteTo(&sources)
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	return sources
+}
+
+// the sources for each name given to crawl --source
+func (bc *BookCollector) listSources(names []string) []ListSource {
+	sources := []ListSource{}
+	var saved []savedSource
+	for _, name := range names {
+		switch name {
+		case "search":
+			for _, category := range categories {
+				for _, sort := range sorts {
+					sources = append(sources, categorySearch{category, sort})
+				}
+				// and the rest of the big ones, see partition.go
+				if bc.splitSearches {
+					sources = append(sources, bc.searchSlices(category)...)
+				}
+			}
+		case "bestsellers":
+			for _, category := range categories {
+				sources = append(sources, chart{"bestsellers", baseBestSellersUrl, category})
+			}
+		case "new-releases":
+			for _, category := range categories {
+				sources = append(sources, chart{"new-releases", baseNewReleasesUrl, category})
+			}
+		case "authors":
+			sources = append(sources, bc.authorSources()...)
+		case "series":
+			sources = append(sources, bc.seriesSources()...)
+		default:
+			if saved == nil {
+				saved = bc.loadSavedSources()
+			}
+			found := false
+			for _, s := range saved {
+				if name == "saved" || name == s.SourceName {
+					sources = append(sources, s)
+					found = true
+				}
+			}
+			if !found && name != "saved" {
+				log.Fatalf("ERR!: unknown source '%s'", name)
+			}
+		}
+	}
+	return sources
+}
+
+// where we are, or "" if we're not on a list, e.g. a revisit
+func (bc *BookCollector) sourceName() string {
+	if bc.currentSource == nil {
+		return ""
+	}
+	return bc.currentSource.Name()
+}
+
+type sighting struct {
+	Id     string    `json:"asin"`
+	Source string    `json:"source"`
+	Rank   int       `json:"rank"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+func (bc *BookCollector) addSightingToDB(id string) {
+	if id == "" || bc.currentSource == nil {
+		return
+	}
+	s := sighting{Id: id, Source: bc.sourceName(), Rank: bc.currentRank, SeenAt: time.Now().UTC()}
+	_, _, err := bc.db.From("book_sightings").Insert(s, false, "", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: book_sightings: id:%s %s", id, err)
+	}
+}
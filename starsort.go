@@ -2,44 +2,275 @@
 
 package main
 
-import(
-	// "fmt"
+import (
+	"errors"
+	"fmt"
+	"log"
 	"math"
+	"os"
+	"strconv"
+
+	"github.com/supabase-community/supabase-go"
 )
 
-// 	http://www.evanmiller.org/ranking-items-with-star-ratings.html
+// Rankers
+//
+// A Ranker turns a ratings histogram, 5★ first, into a single score out of 5.
+// Which one we use, and its settings, come from .env:
+//
+// 	RANKER=dirichlet     # Evan Miller's method (the default), uses RANKER_Z
+// 	RANKER=bayesian      # Bayesian average, uses RANKER_PRIOR_MEAN and RANKER_PRIOR_WEIGHT
+// 	RANKER=wilson        # Wilson lower bound, uses RANKER_Z
+// 	RANKER=mean          # the plain average, as Audible shows it
+//
+// Each ranker's Name includes its settings and is stored with the scores, so
+// we know what produced them.
+
+type Ranker interface {
+	Name() string
+	Rank(ns []int) (float64, error)
+}
+
+var errNoRatings = errors.New("no ratings")
+
+// star values for each histogram bucket
+var stars = []int{5, 4, 3, 2, 1}
 
 func sum(ns []int) int {
 	var total int
-	for _,n := range ns {
+	for _, n := range ns {
 		total += n
 	}
 	return total
 }
 
-func f(s []int, ns []int)float64{
-	N := sum(ns)
-	K := len(ns)
-	ks := make([]int,K)
-	for i:=0; i<5; i++ {
-		ks[i] = s[i] * (ns[i]+1)
+func checkHistogram(ns []int) error {
+	if len(ns) != len(stars) {
+		return fmt.Errorf("histogram has %d buckets, expected %d", len(ns), len(stars))
+	}
+	for i, n := range ns {
+		if n < 0 {
+			return fmt.Errorf("histogram bucket %d★ is negative: %d", stars[i], n)
+		}
+	}
+	return nil
+}
+
+//
+// Dirichlet
+//
+// http://www.evanmiller.org/ranking-items-with-star-ratings.html
+//
+// z = 1.65 is a 95% one-sided confidence, bigger is more pessimistic
+//
+
+type DirichletRanker struct {
+	Z float64
+	// pseudo-votes for each star, nil means one each
+	Prior []float64
+}
+
+func (r DirichletRanker) Name() string {
+	if r.Prior != nil {
+		return fmt.Sprintf("dirichlet(z=%g,prior=%.3g)", r.Z, r.Prior)
+	}
+	return fmt.Sprintf("dirichlet(z=%g)", r.Z)
+}
+
+func (r DirichletRanker) prior(K int) []float64 {
+	if r.Prior != nil {
+		return r.Prior
 	}
-	return float64(sum(ks)) / float64(N+K)
+	prior := make([]float64, K)
+	for i := range prior {
+		prior[i] = 1
+	}
+	return prior
 }
 
-func starSort(ns []int) float64 {
+// expected value of s given the histogram and the prior's pseudo-votes
+func f(s []int, ns []int, prior []float64) float64 {
 	N := sum(ns)
-	K := len(ns)
-	s := []int{5, 4, 3, 2, 1}
+	A := 0.0
+	total := 0.0
+	for i := range ns {
+		A += prior[i]
+		total += float64(s[i]) * (float64(ns[i]) + prior[i])
+	}
+	return total / (float64(N) + A)
+}
+
+func (r DirichletRanker) Rank(ns []int) (float64, error) {
+	if err := checkHistogram(ns); err != nil {
+		return 0, err
+	}
+	prior := r.prior(len(ns))
+	if len(prior) != len(ns) {
+		return 0, fmt.Errorf("prior has %d buckets, expected %d", len(prior), len(ns))
+	}
+	N := float64(sum(ns))
+	A := 0.0
+	for _, a := range prior {
+		A += a
+	}
+	// a prior of nothing and no ratings has no average
+	if N+A == 0 {
+		return 0, errNoRatings
+	}
+	s := stars
 	s2 := []int{25, 16, 9, 4, 1}
-	z := float64(1.65)
-	fsns := f(s, ns)
-	return fsns - z * math.Sqrt(((f(s2, ns) - (fsns*fsns)) / float64(N+K+1)))
+	fsns := f(s, ns, prior)
+	return fsns - r.Z*math.Sqrt(((f(s2, ns, prior)-(fsns*fsns))/(N+A+1))), nil
+}
+
+//
+// Bayesian average
+//
+// pretend every book starts with PriorWeight ratings of PriorMean, usually
+// the average across the whole catalogue, so few ratings stay near the middle
+//
+
+type BayesianRanker struct {
+	PriorMean   float64
+	PriorWeight float64
+}
+
+func (r BayesianRanker) Name() string {
+	return fmt.Sprintf("bayesian(m=%g,c=%g)", r.PriorMean, r.PriorWeight)
+}
+
+func (r BayesianRanker) Rank(ns []int) (float64, error) {
+	if err := checkHistogram(ns); err != nil {
+		return 0, err
+	}
+	total := 0
+	for i, n := range ns {
+		total += stars[i] * n
+	}
+	// with no weight on the prior, no ratings means no average
+	if r.PriorWeight+float64(sum(ns)) == 0 {
+		return 0, errNoRatings
+	}
+	return (r.PriorWeight*r.PriorMean + float64(total)) / (r.PriorWeight + float64(sum(ns))), nil
+}
+
+//
+// Wilson lower bound
+//
+// treats each rating as a fraction of a thumbs-up (5★ = 1, 1★ = 0) and takes
+// the lower bound of the Wilson score interval, scaled back to 1–5
+//
+
+type WilsonRanker struct {
+	Z float64
+}
+
+func (r WilsonRanker) Name() string {
+	return fmt.Sprintf("wilson(z=%g)", r.Z)
+}
+
+func (r WilsonRanker) Rank(ns []int) (float64, error) {
+	if err := checkHistogram(ns); err != nil {
+		return 0, err
+	}
+	N := float64(sum(ns))
+	if N == 0 {
+		return 0, errNoRatings
+	}
+	positive := 0.0
+	for i, n := range ns {
+		positive += float64(stars[i]-1) / 4.0 * float64(n)
+	}
+	p := positive / N
+	z2 := r.Z * r.Z
+	lower := (p + z2/(2*N) - r.Z*math.Sqrt((p*(1-p)+z2/(4*N))/N)) / (1 + z2/N)
+	return 1 + 4*lower, nil
+}
+
+//
+// Mean
+//
+// the plain average, for comparison
+//
+
+type MeanRanker struct{}
+
+func (r MeanRanker) Name() string {
+	return "mean"
 }
 
-// func main(){
-// 	fmt.Println(starSort([]int{60, 80, 75, 20, 25}))
-// 	fmt.Println(starSort([]int{1000, 0, 0, 0, 0}))
-// 	fmt.Println(starSort([]int{1, 0, 0, 0, 0}))
-// 	fmt.Println(starSort([]int{10, 0, 0, 0, 0}))
-// }
+func (r MeanRanker) Rank(ns []int) (float64, error) {
+	if err := checkHistogram(ns); err != nil {
+		return 0, err
+	}
+	N := sum(ns)
+	if N == 0 {
+		return 0, errNoRatings
+	}
+	total := 0
+	for i, n := range ns {
+		total += stars[i] * n
+	}
+	return float64(total) / float64(N), nil
+}
+
+func envFloat(name string, fallback float64) float64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatalf("ERR!: %s: %s", name, err)
+	}
+	return v
+}
+
+// the mean of every stored rating, pooled across all books
+func globalMeanRating(db *supabase.Client) float64 {
+	pooled := make([]int, len(stars))
+	err := forEachRow(db, "books", "asin,ratingsoverall", func(b Book) {
+		ns, err := stringsToInts(b.RatingsOverall)
+		if err != nil || checkHistogram(ns) != nil {
+			return
+		}
+		for i, n := range ns {
+			pooled[i] += n
+		}
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	mean, err := MeanRanker{}.Rank(pooled)
+	if err != nil {
+		log.Fatal("ERR!: global mean rating:", err)
+	}
+	return mean
+}
+
+// the ranker set in .env
+func loadRanker(db *supabase.Client) Ranker {
+	var ranker Ranker
+	switch name := os.Getenv("RANKER"); name {
+	case "dirichlet", "":
+		ranker = DirichletRanker{Z: envFloat("RANKER_Z", 1.65)}
+	case "bayesian":
+		// the prior defaults to the average rating across everything we have
+		priorMean := envFloat("RANKER_PRIOR_MEAN", 0)
+		if priorMean == 0 {
+			priorMean = globalMeanRating(db)
+		}
+		ranker = BayesianRanker{
+			PriorMean:   priorMean,
+			PriorWeight: envFloat("RANKER_PRIOR_WEIGHT", 10),
+		}
+	case "wilson":
+		ranker = WilsonRanker{Z: envFloat("RANKER_Z", 1.65)}
+	case "mean":
+		ranker = MeanRanker{}
+	default:
+		log.Fatalf("ERR!: unknown RANKER '%s'", name)
+	}
+	log.Printf("INFO: ranker: %s\n", ranker.Name())
+	return ranker
+}
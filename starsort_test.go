@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRankers(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranker Ranker
+		ns     []int
+		want   float64
+		err    error
+	}{
+		{"mean", MeanRanker{}, []int{1, 0, 0, 0, 1}, 3, nil},
+		{"mean all 5", MeanRanker{}, []int{7, 0, 0, 0, 0}, 5, nil},
+		{"mean no ratings", MeanRanker{}, []int{0, 0, 0, 0, 0}, 0, errNoRatings},
+
+		{"bayesian", BayesianRanker{PriorMean: 4, PriorWeight: 10}, []int{10, 0, 0, 0, 0}, 4.5, nil},
+		{"bayesian no ratings", BayesianRanker{PriorMean: 4, PriorWeight: 10}, []int{0, 0, 0, 0, 0}, 4, nil},
+		{"bayesian no weight", BayesianRanker{PriorMean: 4, PriorWeight: 0}, []int{0, 0, 0, 1, 0}, 2, nil},
+		{"bayesian no weight no ratings", BayesianRanker{PriorMean: 4, PriorWeight: 0}, []int{0, 0, 0, 0, 0}, 0, errNoRatings},
+
+		{"wilson z=0 is the mean", WilsonRanker{Z: 0}, []int{1, 0, 0, 0, 1}, 3, nil},
+		{"wilson no ratings", WilsonRanker{Z: 1.65}, []int{0, 0, 0, 0, 0}, 0, errNoRatings},
+
+		{"dirichlet z=0 no ratings is the prior", DirichletRanker{Z: 0}, []int{0, 0, 0, 0, 0}, 3, nil},
+		{"dirichlet z=0", DirichletRanker{Z: 0}, []int{5, 0, 0, 0, 0}, 4, nil},
+		{"dirichlet empty prior no ratings", DirichletRanker{Z: 1.65, Prior: []float64{0, 0, 0, 0, 0}}, []int{0, 0, 0, 0, 0}, 0, errNoRatings},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ranker.Rank(tt.ns)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Rank(%v) error = %v, want %v", tt.ns, err, tt.err)
+			}
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Rank(%v) = %v, want %v", tt.ns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankersRejectBadHistograms(t *testing.T) {
+	rankers := []Ranker{
+		DirichletRanker{Z: 1.65},
+		BayesianRanker{PriorMean: 4, PriorWeight: 10},
+		WilsonRanker{Z: 1.65},
+		MeanRanker{},
+	}
+	histograms := map[string][]int{
+		"empty":    {},
+		"nil":      nil,
+		"short":    {3, 2, 1},
+		"long":     {1, 1, 1, 1, 1, 1},
+		"negative": {5, 4, -1, 0, 0},
+	}
+	for _, r := range rankers {
+		for name, ns := range histograms {
+			if got, err := r.Rank(ns); err == nil {
+				t.Errorf("%s: %s histogram %v = %v, want an error", r.Name(), name, ns, got)
+			}
+		}
+	}
+}
+
+// more ratings at the same average should rank higher, and never above it
+func TestRankersTrustMoreRatings(t *testing.T) {
+	rankers := []Ranker{
+		DirichletRanker{Z: 1.65},
+		BayesianRanker{PriorMean: 3, PriorWeight: 10},
+		WilsonRanker{Z: 1.65},
+	}
+	few := []int{2, 0, 0, 0, 0}
+	many := []int{200, 0, 0, 0, 0}
+	for _, r := range rankers {
+		a, err := r.Rank(few)
+		if err != nil {
+			t.Fatalf("%s: %v", r.Name(), err)
+		}
+		b, err := r.Rank(many)
+		if err != nil {
+			t.Fatalf("%s: %v", r.Name(), err)
+		}
+		if !(a < b && b <= 5) {
+			t.Errorf("%s: %v ratings = %v, %v ratings = %v, want fewer < more <= 5", r.Name(), few, a, many, b)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// store.go
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/supabase-community/supabase-go"
+	"github.com/supabase/postgrest-go"
+)
+
+// Date is a postgres date column, which postgrest hands back as 2006-01-02
+// rather than the RFC 3339 time.Time expects
+type Date struct {
+	time.Time
+}
+
+const dateFormat = "2006-01-02"
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format(dateFormat))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	s := ""
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		// null
+		d.Time = time.Time{}
+		return nil
+	}
+	t, err := time.Parse(dateFormat, s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	d.Time = t
+	return err
+}
+
+// postgrest won't hand back more than a thousand or so rows at once, so
+// anything that needs the whole table has to page through it
+const dbPageSize = 1000
+
+// postgrest-go sorts descending unless it's told otherwise
+var ascending = &postgrest.OrderOpts{Ascending: true}
+
+// calls each for every row in table, in ascending order of asin so the pages
+// are stable, then of id, which every table has, to tell apart rows with the
+// same asin, so for each asin the last row is the newest
+func forEachRow[T any](db *supabase.Client, table, columns string, each func(row T)) error {
+	return forEachPage(db, table, columns, nil, func(rows []T) error {
+		for _, row := range rows {
+			each(row)
+		}
+		return nil
+	})
+}
+
+// calls each with every page of rows in table that pass where (which can be
+// nil), in ascending order of asin and then id so the pages are stable
+func forEachPage[T any](db *supabase.Client, table, columns string, where func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder, each func(rows []T) error) error {
+	for from := 0; ; from += dbPageSize {
+		rows := []T{}
+		q := db.From(table).Select(columns, "", false)
+		if where != nil {
+			q = where(q)
+		}
+		_, err := q.Order("asin", ascending).Order("id", ascending).Range(from, from+dbPageSize-1, "").ExecuteTo(&rows)
+		if err != nil {
+			return err
+		}
+		if err := each(rows); err != nil {
+			return err
+		}
+		if len(rows) < dbPageSize {
+			return nil
+		}
+	}
+}
+
+// asins per query when looking things up for a page of books, to keep the URL short
+const dbLookupSize = 200
+
+// the tags table rows for a page of books, by asin
+func loadTagsFor(db *supabase.Client, ids []string) (map[string][]string, error) {
+	tags := map[string][]string{}
+	for from := 0; from < len(ids); from += dbLookupSize {
+		to := from + dbLookupSize
+		if to > len(ids) {
+			to = len(ids)
+		}
+		rows := []map[string]string{}
+		_, err := db.From("tags").Select("asin,tag", "", false).In("asin", ids[from:to]).ExecuteTo(&rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			tags[row["asin"]] = append(tags[row["asin"]], row["tag"])
+		}
+	}
+	return tags, nil
+}
+
+// the tags table rows for a book, which includes the category tags
+func loadBookTags(db *supabase.Client, id string) ([]string, error) {
+	rows := []map[string]string{}
+	_, err := db.From("tags").Select("tag", "", false).Eq("asin", id).ExecuteTo(&rows)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, len(rows))
+	for i, row := range rows {
+		tags[i] = row["tag"]
+	}
+	return tags, nil
+}
@@ -0,0 +1,285 @@
+// suspects.go
+
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Suspicious books
+//
+// Some books look gamed: top of the popularity lists with a 2.4★ weighted
+// rating, or a histogram like ["98","1","0","0","1"]. The suspects command
+// scores every book for manipulation risk from five signals, each 0–1:
+//
+// 	shape        almost all 5★ with next to nothing in the middle
+// 	mismatch     very popular, but with few ratings or a low rating
+// 	burst        ratings arriving much faster in one spell than usual
+// 	duplicate    a near-identical title, or the same cover, from the same author
+// 	reviews      the top reviews are mostly short and glowing, or alike
+//
+// and combines them as 1 - (1-a)(1-b)…, so one strong signal or a few weak
+// ones will do. Anything over SUSPECT_THRESHOLD (default 0.6) goes into
+// suspect_books for review and is marked suspect on books, which leaves it out
+// of the rankings by default. Setting its status to 'cleared' in suspect_books
+// stops it being flagged again. A book that drops back under the threshold
+// before anyone has looked at it is unmarked, and its pending row removed.
+
+const (
+	suspectPending   = "pending"
+	suspectCleared   = "cleared"
+	suspectConfirmed = "confirmed"
+)
+
+var findRatingsCountRx = regexp.MustCompile(`(?i)([\d,]+)\s+ratings?\b`)
+
+type suspectBook struct {
+	Id        string             `json:"asin"`
+	Title     string             `json:"title"`
+	Risk      float64            `json:"risk"`
+	Signals   map[string]float64 `json:"signals"`
+	Status    string             `json:"status"`
+	FlaggedAt time.Time          `json:"flagged_at"`
+}
+
+type ratingSnapshot struct {
+	Id      string    `json:"asin"`
+	Ratings int       `json:"ratings"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// list pages show '4,154 ratings', we keep every sighting so we can see how fast they arrive
+func (bc *BookCollector) addRatingHistoryToDB(id, productText string) {
+	m := findRatingsCountRx.FindStringSubmatch(productText)
+	if id == "" || len(m) == 0 {
+		return
+	}
+	ratings, err := strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	if err != nil {
+		return
+	}
+	_, _, err = bc.db.From("rating_history").Insert(ratingSnapshot{Id: id, Ratings: ratings, SeenAt: time.Now().UTC()}, false, "", "", "").Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: rating_history: id:%s %s", id, err)
+	}
+}
+
+// almost all 5★, with the middle empty, which real books with a few
+// hundred ratings rarely manage
+func shapeSignal(ns []int) float64 {
+	N := float64(sum(ns))
+	if N < 10 {
+		return 0
+	}
+	top := float64(ns[0]) / N
+	middle := float64(ns[1]+ns[2]+ns[3]) / N
+	if top < 0.85 {
+		return 0
+	}
+	// lots of ratings makes a lopsided histogram more believable
+	return clamp01(1-middle/0.08) * clamp01(1-math.Log10(N)/4)
+}
+
+// very popular with hardly any ratings, or a poor rating
+func mismatchSignal(popularity, rating float64, ratings int) float64 {
+	popular := clamp01(popularity / 500)
+	fewRatings := clamp01(1 - math.Log1p(float64(ratings))/math.Log1p(200))
+	lowRating := 0.0
+	if rating > 0 {
+		lowRating = clamp01((3.5 - rating) / 1.5)
+	}
+	return popular * math.Max(fewRatings, lowRating)
+}
+
+// the fastest spell of new ratings compared to the usual rate
+func burstSignal(history []ratingSnapshot) float64 {
+	sort.Slice(history, func(i, j int) bool { return history[i].SeenAt.Before(history[j].SeenAt) })
+	rates := []float64{}
+	for i := 1; i < len(history); i++ {
+		days := history[i].SeenAt.Sub(history[i-1].SeenAt).Hours() / 24
+		if days < 0.5 {
+			continue
+		}
+		rates = append(rates, float64(history[i].Ratings-history[i-1].Ratings)/days)
+	}
+	if len(rates) < 3 {
+		return 0
+	}
+	total := history[len(history)-1].Ratings - history[0].Ratings
+	if total < 20 {
+		return 0
+	}
+	sorted := append([]float64{}, rates...)
+	sort.Float64s(sorted)
+	median := math.Max(sorted[len(sorted)/2], 0.1)
+	return clamp01((sorted[len(sorted)-1]/median - 3) / 7)
+}
+
+var titleWordsRx = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// lower case words, without the noise that varies between editions
+func titleWords(title string) map[string]bool {
+	words := map[string]bool{}
+	for _, w := range titleWordsRx.FindAllString(strings.ToLower(title), -1) {
+		switch w {
+		case "the", "a", "an", "unabridged", "edition", "audiobook":
+			continue
+		}
+		words[w] = true
+	}
+	return words
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	both := 0
+	for w := range a {
+		if b[w] {
+			both++
+		}
+	}
+	return float64(both) / float64(len(a)+len(b)-both)
+}
+
+// the most similar other title by the same author, where the same cover
+// (see covers.go) is as good as a near-identical title
+func duplicateSignal(b Book, byAuthor map[string][]Book, covers map[string]cover) float64 {
+	words := titleWords(b.Title)
+	best := 0.0
+	for _, other := range byAuthor[b.Author] {
+		if other.Id == b.Id {
+			continue
+		}
+		best = math.Max(best, jaccard(words, titleWords(other.Title)))
+		if sameCover(covers[b.Id], covers[other.Id]) {
+			best = math.Max(best, 0.9)
+		}
+	}
+	return clamp01((best - 0.6) / 0.4)
+}
+
+// the share of generic reviews, from review_signals, over a third is odd
+func reviewsSignal(s reviewSignals) float64 {
+	return clamp01((s.Generic - 0.3) / 0.5)
+}
+
+func combineSignals(signals map[string]float64) float64 {
+	notRisky := 1.0
+	for _, s := range signals {
+		notRisky *= 1 - s
+	}
+	return 1 - notRisky
+}
+
+func (bc *BookCollector) findSuspects(args []string) {
+	flags := flag.NewFlagSet("suspects", flag.ExitOnError)
+	threshold := flags.Float64("threshold", envFloat("SUSPECT_THRESHOLD", 0.6), "flag books with a risk at least this high")
+	dryRun := flags.Bool("dry-run", false, "report suspects without flagging them")
+	flags.Parse(args)
+
+	books := []Book{}
+	byAuthor := map[string][]Book{}
+	err := forEachRow(bc.db, "books", "asin,title,author,rating,popularity,ratingsoverall,hidden,suspect", func(b Book) {
+		if b.Hidden {
+			return
+		}
+		books = append(books, b)
+		byAuthor[b.Author] = append(byAuthor[b.Author], b)
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	history := map[string][]ratingSnapshot{}
+	err = forEachRow(bc.db, "rating_history", "asin,ratings,seen_at", func(r ratingSnapshot) {
+		history[r.Id] = append(history[r.Id], r)
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	covers := loadCovers(bc.db)
+	reviews := map[string]reviewSignals{}
+	err = forEachRow(bc.db, "review_signals", "asin,generic", func(s reviewSignals) {
+		reviews[s.Id] = s
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+	// a human has already looked at these
+	reviewed := map[string]string{}
+	err = forEachRow(bc.db, "suspect_books", "asin,status", func(s suspectBook) {
+		reviewed[s.Id] = s.Status
+	})
+	if err != nil {
+		log.Fatal("ERR!: DATABASE:", err)
+	}
+
+	flagged, unflagged := 0, 0
+	for _, b := range books {
+		signals := map[string]float64{}
+		ratings := 0
+		if ns, err := stringsToInts(b.RatingsOverall); err == nil && checkHistogram(ns) == nil {
+			ratings = sum(ns)
+			signals["shape"] = shapeSignal(ns)
+		}
+		signals["mismatch"] = mismatchSignal(b.PopularityScore, b.Rating, ratings)
+		signals["burst"] = burstSignal(history[b.Id])
+		signals["duplicate"] = duplicateSignal(b, byAuthor, covers)
+		signals["reviews"] = reviewsSignal(reviews[b.Id])
+		risk := combineSignals(signals)
+		status := reviewed[b.Id]
+		if risk < *threshold {
+			// not suspect any more, unless a human said so
+			if status == suspectPending || (b.Suspect && status != suspectConfirmed) {
+				unflagged++
+				log.Printf("- - UNSUSPECT: %s %.2f %s, by %s", b.Id, risk, b.Title, b.Author)
+				if !*dryRun {
+					bc.unflagSuspect(b.Id, status)
+				}
+			}
+			continue
+		}
+		if status == suspectCleared || status == suspectConfirmed {
+			continue
+		}
+		flagged++
+		log.Printf("- SUSPECT: %s %.2f %s, by %s %.2v", b.Id, risk, b.Title, b.Author, signals)
+		if *dryRun {
+			continue
+		}
+		s := suspectBook{Id: b.Id, Title: b.Title, Risk: risk, Signals: signals, Status: suspectPending, FlaggedAt: time.Now().UTC()}
+		_, _, err := bc.db.From("suspect_books").Upsert(s, "asin", "", "").Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: suspect_books: id:%s %s", b.Id, err)
+			continue
+		}
+		_, _, err = bc.db.From("books").Update(map[string]interface{}{"suspect": true}, "", "").Eq("asin", b.Id).Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: books: id:%s %s", b.Id, err)
+		}
+	}
+	log.Printf("SUSPECTS: %d of %d books flagged for review, %d no longer suspect\n", flagged, len(books), unflagged)
+}
+
+// unmarks a book that's fallen under the threshold, and drops it from review
+func (bc *BookCollector) unflagSuspect(id, status string) {
+	if status == suspectPending {
+		_, _, err := bc.db.From("suspect_books").Delete("", "").Eq("asin", id).Eq("status", suspectPending).Execute()
+		if err != nil {
+			log.Printf("ERR!: DATABASE: suspect_books: id:%s %s", id, err)
+			return
+		}
+	}
+	_, _, err := bc.db.From("books").Update(map[string]interface{}{"suspect": false}, "", "").Eq("asin", id).Execute()
+	if err != nil {
+		log.Printf("ERR!: DATABASE: books: id:%s %s", id, err)
+	}
+}